@@ -0,0 +1,75 @@
+package mapqueryparam_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/h-celel/mapqueryparam"
+)
+
+type benchPage struct {
+	Page    int `mqp:"page"`
+	PerPage int `mqp:"per_page,limit"`
+}
+
+type benchRequest struct {
+	benchPage
+	UserID  string    `json:"user_id"`
+	Query   string    `mqp:"q,query"`
+	Tags    []string  `mqp:"tag"`
+	Since   time.Time `mqp:"since"`
+	Score   float64
+	Enabled bool
+	Exclude *bool
+	Sort    [2]string `mqp:"sort"`
+}
+
+var benchQuery = map[string][]string{
+	"page":     {"3"},
+	"limit":    {"50"},
+	"user_id":  {"a2b4c6"},
+	"query":    {"foo bar"},
+	"tag":      {"a", "b", "c"},
+	"since":    {"2021-03-04T05:06:07Z"},
+	"Score":    {"0.75"},
+	"Enabled":  {"true"},
+	"Exclude":  {"false"},
+	"sort":     {"name", "desc"},
+	"unused":   {"x"},
+	"_":        {"1633000000"},
+	"per_page": {},
+}
+
+func BenchmarkEncode(b *testing.B) {
+	exclude := true
+	r := benchRequest{
+		benchPage: benchPage{Page: 3, PerPage: 50},
+		UserID:    "a2b4c6",
+		Query:     "foo bar",
+		Tags:      []string{"a", "b", "c"},
+		Since:     time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Score:     0.75,
+		Enabled:   true,
+		Exclude:   &exclude,
+		Sort:      [2]string{"name", "desc"},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := mapqueryparam.Encode(&r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var r benchRequest
+		if err := mapqueryparam.Decode(benchQuery, &r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
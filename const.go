@@ -1,5 +1,76 @@
 package mapqueryparam
 
+import "time"
+
 const (
 	mapQueryParameterTagName string = "mqp"
+
+	defaultMaxIndex int = 1000
+	defaultMaxDepth int = 32
+
+	nestedOption     string = "nested"
+	sliceOption      string = "slice"
+	keepZeroOption   string = "keepzero"
+	omitEmptyOption  string = "omitempty"
+	encodeOnlyOption string = "encodeonly"
+	decodeOnlyOption string = "decodeonly"
+	requiredOption   string = "required"
+	remainOption     string = "remain"
+	// requiredWithOption holds the names of other parameters, separated by pipes, which make the field required when
+	// any of them is present, e.g. `mqp:"end,required_with=start"`.
+	requiredWithOption string = "required_with"
+	// defaultOption holds the value of the field when its parameter is absent. The values of arrays and slices are
+	// separated by pipes, e.g. `mqp:"sort,default=name|age"`.
+	defaultOption string = "default"
+	// timeOption holds the format of time.Time values, which is a layout, the name of a predefined layout, unix or
+	// unixmilli, e.g. `mqp:"since,time=2006-01-02"`.
+	timeOption string = "time"
+
+	// constraints checked when decoding
+	minOption      string = "min"
+	maxOption      string = "max"
+	lenOption      string = "len"
+	oneOfOption    string = "oneof"
+	patternOption  string = "pattern"
+	maxItemsOption string = "maxitems"
+
+	// skipTag is the tag excluding a field from both encoding and decoding.
+	skipTag string = "-"
+	// reservedNameChars holds the characters which names of the struct tag cannot contain. They're found in names when
+	// an option value containing a comma is split, e.g. `mqp:"code,pattern=^[a-z]{2,3}$"`.
+	reservedNameChars string = "{}()|*+?^$\\"
+
+	// unixTimeFormat and unixMilliTimeFormat represent time.Time values as the number of seconds or milliseconds
+	// since the unix epoch.
+	unixTimeFormat      string = "unix"
+	unixMilliTimeFormat string = "unixmilli"
 )
+
+// flagOptions holds the options of the struct tag which take no value, e.g. `mqp:"page,keepzero"`. Other entries
+// without a value are names, as well as the first entry, so `mqp:"required"` names the field "required".
+var flagOptions = map[string]bool{
+	keepZeroOption:   true,
+	omitEmptyOption:  true,
+	encodeOnlyOption: true,
+	decodeOnlyOption: true,
+	requiredOption:   true,
+	remainOption:     true,
+}
+
+// timeLayouts holds the predefined layouts which can be named by the time format, as layouts such as time.RFC1123
+// contain commas and cannot be part of a struct tag.
+var timeLayouts = map[string]string{
+	"ansic":       time.ANSIC,
+	"unixdate":    time.UnixDate,
+	"rfc822":      time.RFC822,
+	"rfc822z":     time.RFC822Z,
+	"rfc850":      time.RFC850,
+	"rfc1123":     time.RFC1123,
+	"rfc1123z":    time.RFC1123Z,
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"kitchen":     time.Kitchen,
+	"datetime":    "2006-01-02 15:04:05",
+	"dateonly":    "2006-01-02",
+	"timeonly":    "15:04:05",
+}
@@ -0,0 +1,121 @@
+package mapqueryparam
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// EncodeFunc encodes a value of a registered type as a parameter string.
+type EncodeFunc func(v interface{}) (string, error)
+
+// DecodeFunc decodes a parameter string as a value of a registered type. The returned value must be assignable to the
+// registered type.
+type DecodeFunc func(s string) (interface{}, error)
+
+// converter holds the functions registered for a type.
+type converter struct {
+	encode EncodeFunc
+	decode DecodeFunc
+	// format returns the converter using the given time format and location. It's only set for the built-in converter
+	// of time.Time, whose functions depend on the options of the field.
+	format func(format string, loc *time.Location) converter
+}
+
+// WithConverter registers functions used to encode and decode values of the given type, taking precedence over any
+// other handling of the type. Either function may be nil, in which case the type is handled as usual in that direction.
+// Converters are useful for types from other packages that cannot implement the marshaling interfaces.
+func WithConverter(t reflect.Type, enc EncodeFunc, dec DecodeFunc) Option {
+	return func(c *config) {
+		c.converters[t] = converter{encode: enc, decode: dec}
+	}
+}
+
+// RegisterConverter registers functions used to encode values of the given type, the same way as WithConverter. It
+// must not be called concurrently with encoding.
+func (e *Encoder) RegisterConverter(t reflect.Type, enc EncodeFunc, dec DecodeFunc) {
+	e.config.registerConverter(t, enc, dec)
+}
+
+// RegisterConverter registers functions used to decode values of the given type, the same way as WithConverter. It
+// must not be called concurrently with decoding.
+func (d *Decoder) RegisterConverter(t reflect.Type, enc EncodeFunc, dec DecodeFunc) {
+	d.config.registerConverter(t, enc, dec)
+}
+
+// registerConverter stores the converter and discards the plans compiled without it.
+func (c *config) registerConverter(t reflect.Type, enc EncodeFunc, dec DecodeFunc) {
+	c.converters[t] = converter{encode: enc, decode: dec}
+	c.plans.reset()
+}
+
+// valueEncoder returns a value encoder calling the encode function of the converter.
+func (conv converter) valueEncoder() valueEncoder {
+	return func(v reflect.Value) (string, error) {
+		return conv.encode(v.Interface())
+	}
+}
+
+// valueDecoder returns a value decoder calling the decode function of the converter, and storing the result in values
+// of the given type.
+func (conv converter) valueDecoder(t reflect.Type) valueDecoder {
+	return func(s string, v reflect.Value) error {
+		i, err := conv.decode(s)
+		if err != nil {
+			return err
+		}
+		if i == nil {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+
+		res := reflect.ValueOf(i)
+		if !res.Type().AssignableTo(t) {
+			return fmt.Errorf("converter returned value of type %s, expected %s", res.Type().String(), t.String())
+		}
+		v.Set(res)
+		return nil
+	}
+}
+
+// converter returns the converter handling values of the given type, if any. Built-in converters, which registered
+// converters replace, are resolved using the time format of the field options.
+func (c *config) converter(t reflect.Type, opts fieldOptions) (converter, bool) {
+	conv, ok := c.converters[t]
+	if ok && conv.format != nil {
+		format := c.timeLayout
+		if opts.time != "" {
+			format = opts.time
+		}
+		conv = conv.format(format, c.timeLocation)
+	}
+	return conv, ok
+}
+
+// timeConverter returns the built-in converter for time.Time, formatting times using the given format. Times are
+// converted to the given location when encoding, if any.
+func timeConverter(format string, loc *time.Location) converter {
+	if layout, ok := timeLayouts[format]; ok {
+		format = layout
+	}
+	return converter{
+		encode: func(v interface{}) (string, error) {
+			t := v.(time.Time)
+			if loc != nil {
+				t = t.In(loc)
+			}
+			switch format {
+			case unixTimeFormat:
+				return strconv.FormatInt(t.Unix(), 10), nil
+			case unixMilliTimeFormat:
+				return strconv.FormatInt(t.Unix()*1e3+int64(t.Nanosecond())/1e6, 10), nil
+			default:
+				return t.Format(format), nil
+			}
+		},
+		decode: func(s string) (interface{}, error) {
+			return parseTime(s, format, loc)
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package mapqueryparam_test
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/h-celel/mapqueryparam"
+)
+
+var bigIntType = reflect.TypeOf(&big.Int{})
+
+func encodeBigInt(v interface{}) (string, error) {
+	return v.(*big.Int).String(), nil
+}
+
+func decodeBigInt(s string) (interface{}, error) {
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, errors.New("invalid integer")
+	}
+	return i, nil
+}
+
+func TestConverter(t *testing.T) {
+	type S struct {
+		A *big.Int
+		B []*big.Int
+	}
+
+	opts := []mapqueryparam.Option{mapqueryparam.WithConverter(bigIntType, encodeBigInt, decodeBigInt)}
+
+	s := S{A: big.NewInt(12), B: []*big.Int{big.NewInt(3), big.NewInt(4)}}
+	query := map[string][]string{"A": {"12"}, "B": {"3", "4"}}
+
+	got, err := mapqueryparam.NewEncoder(opts...).Encode(s)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, query) {
+		t.Errorf("Encode() got = %v, want %v", got, query)
+	}
+
+	var s2 S
+	if err := mapqueryparam.NewDecoder(opts...).Decode(query, &s2); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(s2, s) {
+		t.Errorf("Decode() got = %v, want %v", s2, s)
+	}
+
+	if err := mapqueryparam.NewDecoder(opts...).Decode(map[string][]string{"A": {"x"}}, &s2); err == nil {
+		t.Errorf("Decode() expected error for invalid integer")
+	}
+}
+
+func TestRegisterConverter(t *testing.T) {
+	type S struct {
+		A time.Time
+	}
+
+	s := S{A: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)}
+
+	enc := mapqueryparam.NewEncoder()
+	if _, err := enc.Encode(s); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	// registering a converter replaces the built-in one for time.Time, also for types that are already cached
+	enc.RegisterConverter(reflect.TypeOf(time.Time{}), func(v interface{}) (string, error) {
+		return v.(time.Time).Format("20060102"), nil
+	}, nil)
+
+	got, err := enc.Encode(s)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{"A": {"20210304"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+
+	dec := mapqueryparam.NewDecoder()
+	dec.RegisterConverter(reflect.TypeOf(time.Time{}), nil, func(s string) (interface{}, error) {
+		return "not a time", nil
+	})
+
+	var s2 S
+	if err := dec.Decode(map[string][]string{"A": {"20210304"}}, &s2); err == nil {
+		t.Errorf("Decode() expected error for converter returning wrong type")
+	}
+}
@@ -1,37 +1,89 @@
 package mapqueryparam
 
 import (
+	"encoding"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net/url"
+	"path"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
 var zeroValue reflect.Value
 
+// QueryParamUnmarshaler is implemented by types that decode themselves from the full set of values of a query
+// parameter.
+type QueryParamUnmarshaler interface {
+	UnmarshalQueryParam([]string) error
+}
+
+// Decoder decodes query parameters into structs. A Decoder is configured once using options and is safe for concurrent
+// use.
+type Decoder struct {
+	config config
+}
+
+// NewDecoder returns a Decoder configured with the given options.
+func NewDecoder(opts ...Option) *Decoder {
+	return &Decoder{config: newConfig(opts)}
+}
+
+// defaultDecoder is used by the package level decoding functions.
+var defaultDecoder = NewDecoder()
+
 // DecodeValues takes a set of query parameters and uses reflection to decode the content into an output structure.
 // Output must be a pointer to a struct. Same as Decode.
 func DecodeValues(query url.Values, v interface{}) error {
-	return Decode(query, v)
+	return defaultDecoder.DecodeValues(query, v)
 }
 
 // Decode takes a set of query parameters and uses reflection to decode the content into an output structure.
 // Output must be a pointer to a struct. Same as DecodeValues.
 func Decode(query map[string][]string, v interface{}) error {
+	return defaultDecoder.Decode(query, v)
+}
+
+// DecodeValues takes a set of query parameters and uses reflection to decode the content into an output structure.
+// Output must be a pointer to a struct. Same as Decode.
+func (d *Decoder) DecodeValues(query url.Values, v interface{}) error {
+	return d.Decode(query, v)
+}
+
+// Decode takes a set of query parameters and uses reflection to decode the content into an output structure.
+// Output must be a pointer to a struct. Same as DecodeValues.
+func (d *Decoder) Decode(query map[string][]string, v interface{}) error {
+	return d.decode(query, v, nil)
+}
+
+// decode decodes the query into the output structure, recording the metadata of the decoding if meta isn't nil. Panics,
+// e.g. of unmarshaling methods, are returned as errors.
+func (d *Decoder) decode(query map[string][]string, v interface{}, meta *Metadata) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = newDecodeError(ErrInternal, fmt.Sprintf("panic while decoding: %v", r), "", nil)
+		}
+	}()
+
 	val := reflect.ValueOf(v)
 	t := reflect.TypeOf(v)
 
 	if val.Kind() != reflect.Ptr {
-		return newDecodeError("must decode to pointer", "", nil)
+		return newDecodeError(ErrInvalidTarget, "must decode to pointer", "", nil)
 	}
 
 	for t.Kind() == reflect.Ptr {
 		t = t.Elem()
 
 		if val.IsNil() {
+			if !val.CanSet() {
+				return newDecodeError(ErrInvalidTarget, "cannot decode into nil pointer", "", nil)
+			}
 			val.Set(reflect.New(t))
 		}
 
@@ -39,195 +91,816 @@ func Decode(query map[string][]string, v interface{}) error {
 	}
 
 	if t.Kind() != reflect.Struct {
-		return newDecodeError(fmt.Sprintf("cannot decode into value of type: %s", t.String()), "", nil)
+		return newDecodeError(ErrInvalidTarget, fmt.Sprintf("cannot decode into value of type: %s", t.String()), "", nil)
+	}
+
+	p, err := d.config.structPlan(t, d.config.fieldOptions())
+	if err != nil {
+		return newDecodeError(ErrInvalidTarget, fmt.Sprintf("cannot decode into value of type: %s", t.String()), "", err)
 	}
 
 	newVal := reflect.New(t)
 
-	err := decodeFields(query, val, newVal.Elem())
+	st := &decodeState{query: query, collect: d.config.collectErrors, meta: meta, normalize: d.config.normalizer()}
+	if d.config.strict || p.remain != nil || meta != nil {
+		st.used = make(map[string]bool)
+	}
+
+	_, err = d.config.decodeFields(st, "", val, newVal.Elem(), p)
+	if meta != nil {
+		meta.setKeys(st)
+	}
 	if err != nil {
 		return err
 	}
 
+	// the remain field consumes all parameters not consumed by other fields, so none are unknown
+	var unknown []string
+	if p.remain != nil {
+		decodeRemain(st, fieldByIndexAlloc(newVal.Elem(), p.remain.index))
+	} else if d.config.strict {
+		unknown = st.unused(d.config.allowed)
+	}
+	if st.collect {
+		for _, key := range st.missing {
+			st.errs = append(st.errs, newMissingError([]string{key}))
+		}
+		for _, key := range unknown {
+			st.errs = append(st.errs, newUnknownError([]string{key}))
+		}
+		if len(st.errs) > 0 {
+			return st.errs
+		}
+	} else if len(st.missing) > 0 {
+		return newMissingError(st.missing)
+	} else if len(unknown) > 0 {
+		return newUnknownError(unknown)
+	}
+
 	val.Set(newVal.Elem())
 
 	return nil
 }
 
-// decodeFields iterates over the fields of the value passed to it, decodes the query values appropriate for the field,
-// and stores the values in the field. The original value is also passed and is used for fields that are found in the
-// query.
-func decodeFields(query map[string][]string, oldVal reflect.Value, newVal reflect.Value) error {
-	t := newVal.Type()
-	for i := 0; i < newVal.NumField(); i++ {
-		f := t.Field(i)
-
-		// don't decode to unexported fields
-		isUnexported := f.PkgPath != ""
-		if isUnexported {
+// decodeState holds the state of a single call to Decode.
+type decodeState struct {
+	query map[string][]string
+
+	// nested holds the distinct segments nested under each prefix of the keys of the query. It's built on first use.
+	nested map[string][]string
+	// brackets reports whether any key of the query contains a bracket, once bracketsChecked is set.
+	brackets        bool
+	bracketsChecked bool
+	// missing holds the keys of the required parameters found missing so far.
+	missing []string
+	// collect reports whether the errors of fields are collected in errs, rather than returned.
+	collect bool
+	errs    DecodeErrors
+	// used holds the keys consumed by the fields, when they're tracked. It's nil otherwise.
+	used map[string]bool
+	// meta holds the metadata reported by DecodeWithMetadata. It's nil otherwise.
+	meta *Metadata
+	// normalize returns the normalized form of a key when keys are matched case or separator insensitively, or is nil
+	// if keys are matched exactly. normalized holds the keys of the query by their normalized form, and is built on
+	// first use.
+	normalize  func(key string) string
+	normalized map[string][]string
+	// depth is the number of structs the current field is nested in.
+	depth int
+	// defaults is the number of default values applied so far.
+	defaults int
+}
+
+// lookup returns the values of the given key, and the key of the query they were found by, which is empty if no values
+// were found. Found keys are tracked as used. When keys are normalized, all keys of the query matching the given key
+// are found. The exact key is preferred, followed by the first matching key in sorted order, and an error is returned
+// if the matching keys have different values.
+func (st *decodeState) lookup(key string) ([]string, string, error) {
+	if st.normalize == nil {
+		s := st.query[key]
+		if len(s) == 0 {
+			return s, "", nil
+		}
+		if st.used != nil {
+			st.used[key] = true
+		}
+		return s, key, nil
+	}
+
+	keys := st.matches(key)
+	if len(keys) == 0 {
+		return nil, "", nil
+	}
+
+	match := keys[0]
+	for _, k := range keys {
+		if k == key {
+			match = k
+		}
+	}
+
+	s := st.query[match]
+	for _, k := range keys {
+		if !reflect.DeepEqual(st.query[k], s) {
+			return nil, key, newDecodeError(ErrConflict, fmt.Sprintf("conflicting values for field '%s' in parameters %s",
+				key, strings.Join(keys, ", ")), key, nil)
+		}
+		if st.used != nil {
+			st.used[k] = true
+		}
+	}
+	return s, match, nil
+}
+
+// matches returns the keys of the query with values matching the given key once normalized, in sorted order.
+func (st *decodeState) matches(key string) []string {
+	if st.normalized == nil {
+		st.normalized = make(map[string][]string)
+		for k, s := range st.query {
+			if len(s) > 0 {
+				n := st.normalize(k)
+				st.normalized[n] = append(st.normalized[n], k)
+			}
+		}
+		for _, keys := range st.normalized {
+			sort.Strings(keys)
+		}
+	}
+	return st.normalized[st.normalize(key)]
+}
+
+// unused returns the keys of the query which weren't consumed by any field, in sorted order. Keys without values, and
+// keys matching any of the given patterns, are left out.
+func (st *decodeState) unused(allowed []string) []string {
+	var res []string
+	for key, s := range st.query {
+		if len(s) == 0 || st.used[key] || matchAny(allowed, key) {
 			continue
 		}
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
 
-		fTyp := f.Type
-		fVal := newVal.Field(i)
+// decodeRemain stores the parameters not consumed by any field in the remain field. The field is set to nil if all
+// parameters were consumed.
+func decodeRemain(st *decodeState, v reflect.Value) {
+	keys := st.unused(nil)
+	if len(keys) == 0 {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
 
-		oldFVal := zeroValue
-		if oldVal != zeroValue {
-			oldFVal = oldVal.Field(i)
+	res := make(map[string][]string, len(keys))
+	for _, key := range keys {
+		res[key] = append([]string(nil), st.query[key]...)
+	}
+	v.Set(reflect.ValueOf(res).Convert(v.Type()))
+}
+
+// matchAny reports whether the key matches any of the patterns, using the syntax of path.Match. Malformed patterns
+// don't match any key.
+func matchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
 		}
+	}
+	return false
+}
 
-		// iterate over embedded fields
-		if f.Anonymous {
-			for fTyp.Kind() == reflect.Ptr {
-				fTyp = fTyp.Elem()
+// fail records the error of a field and returns nil when collecting errors, so that decoding continues with the next
+// field. Otherwise it returns the error as is.
+func (st *decodeState) fail(err error) error {
+	if !st.collect {
+		return err
+	}
 
-				if fVal.IsNil() {
-					fVal.Set(reflect.New(fTyp))
-				}
+	var decodeErr DecodeError
+	if !errors.As(err, &decodeErr) {
+		decodeErr = newDecodeError(nil, err.Error(), "", err)
+	}
+	st.errs = append(st.errs, decodeErr)
+	return nil
+}
 
-				if oldFVal != zeroValue && oldFVal.IsNil() {
-					oldFVal = zeroValue
+// segments returns the distinct segments nested under the given prefix in the keys of the query, in sorted order. The
+// prefix ends with an opening bracket or a dot. After a bracket, a segment is the text up to the closing bracket, e.g.
+// `b` is nested under the prefix `a[` in the key `a[b][c]`. After a dot, a segment is the text up to the next dot or
+// opening bracket, e.g. `b` is nested under the prefix `a.` in the key `a.b.c`. When keys are normalized, prefixes are
+// matched in their normalized form, while segments are returned as is.
+func (st *decodeState) segments(prefix string) []string {
+	if st.nested == nil {
+		st.nested = make(map[string][]string)
+
+		seen := make(map[[2]string]bool)
+		for key, s := range st.query {
+			if len(s) == 0 {
+				continue
+			}
+			for i := 0; i < len(key); i++ {
+				var end int
+				switch key[i] {
+				case '[':
+					end = strings.IndexByte(key[i+1:], ']')
+				case '.':
+					end = strings.IndexAny(key[i+1:], ".[")
+					if end < 0 {
+						end = len(key) - i - 1
+					}
+				default:
+					continue
+				}
+				if end < 0 {
+					break
 				}
 
-				fVal = fVal.Elem()
-				if oldFVal != zeroValue {
-					oldFVal = oldFVal.Elem()
+				p, seg := key[:i+1], key[i+1:i+1+end]
+				if st.normalize != nil {
+					p = st.normalize(p)
+				}
+				if !seen[[2]string{p, seg}] {
+					seen[[2]string{p, seg}] = true
+					st.nested[p] = append(st.nested[p], seg)
 				}
 			}
+		}
 
-			err := decodeFields(query, oldFVal, fVal)
-			if err != nil {
-				return err
-			}
+		for _, segs := range st.nested {
+			sort.Strings(segs)
+		}
+	}
+	if st.normalize != nil {
+		prefix = st.normalize(prefix)
+	}
+	return st.nested[prefix]
+}
 
+// sliceIndex is an index of a slice or array, as found in an indexed key.
+type sliceIndex struct {
+	// seg is the index as written in the key.
+	seg string
+	// i is the value of the index, or -1 if it doesn't fit an int.
+	i int
+}
+
+// indices returns the segments nested under the given key in brackets which are indexes, in order of their value,
+// e.g. `0` and `2` for the keys `a[2]` and `a[0]`.
+func (st *decodeState) indices(key string) []sliceIndex {
+	var res []sliceIndex
+	for _, seg := range st.segments(key + "[") {
+		if seg == "" || strings.TrimLeft(seg, "0123456789") != "" {
 			continue
 		}
+		i, err := strconv.Atoi(seg)
+		if err != nil {
+			i = -1
+		}
+		res = append(res, sliceIndex{seg: seg, i: i})
+	}
+	sort.SliceStable(res, func(a, b int) bool {
+		return uint(res[a].i) < uint(res[b].i)
+	})
+	return res
+}
 
-		var s []string
-		var tag string
-		var ok bool
+// freeSlots returns the first n positions of a slice which aren't taken by any of the given indices, in order. The
+// indices must be sorted by value.
+func freeSlots(indices []sliceIndex, n int) []int {
+	res := make([]int, 0, n)
+	next := 0
+	for i := 0; len(res) < n; i++ {
+		if next < len(indices) && indices[next].i == i {
+			// skip duplicate indices, e.g. `a[1]` and `a[01]`
+			for next < len(indices) && indices[next].i == i {
+				next++
+			}
+			continue
+		}
+		res = append(res, i)
+	}
+	return res
+}
 
-		fieldTags := getFieldTags(f)
-		for _, tag = range fieldTags {
-			if s, ok = query[tag]; ok {
+// hasBrackets reports whether any key of the query contains an opening bracket, and so may be an indexed key. It's
+// computed on first use, so queries without brackets are decoded without building the nested segments.
+func (st *decodeState) hasBrackets() bool {
+	if !st.bracketsChecked {
+		st.bracketsChecked = true
+		for key := range st.query {
+			if strings.IndexByte(key, '[') >= 0 {
+				st.brackets = true
 				break
 			}
 		}
-		if len(s) == 0 {
+	}
+	return st.brackets
+}
+
+// decodeFields iterates over the fields of the value passed to it, decodes the query values appropriate for the field,
+// and stores the values in the field. The original value is also passed and is used for fields that are found in the
+// query. The keys of the fields are joined to the given key, when the value is a nested struct. It reports whether any
+// of the fields were found.
+func (c *config) decodeFields(st *decodeState, key string, oldVal, newVal reflect.Value, p *structPlan) (bool, error) {
+	var found bool
+	var present []bool
+	if p.required {
+		present = make([]bool, len(p.fields))
+	}
+	for i, f := range p.fields {
+		fVal := fieldByIndexAlloc(newVal, f.index)
+
+		oldFVal := zeroValue
+		if oldVal != zeroValue {
+			oldFVal = fieldByIndex(oldVal, f.index)
+		}
+
+		// fields excluded from decoding have no decoder, and keep their original value. The key of the field is the key
+		// of the query it was found by, which differs from its name when keys are normalized.
+		var fKey string
+		var err error
+		defaults := st.defaults
+		for _, name := range f.names {
+			if f.decode == nil {
+				break
+			}
+
+			fKey, err = f.decode(st, joinKey(key, name, p.opts.nesting), oldFVal, fVal)
+			if err != nil || fKey != "" {
+				break
+			}
+		}
+		ok := fKey != ""
+		if err == nil && ok && f.validate != nil {
+			if vErr := f.validate(fVal); vErr != nil {
+				err = newValueError(fmt.Sprintf("invalid value in field '%s'", fKey), fKey, vErr)
+			}
+		}
+		if err != nil {
+			// the field counts as found when its error is collected, so it isn't reported as missing as well
+			if err := st.fail(err); err != nil {
+				return true, err
+			}
+		}
+
+		if !ok {
+			// defaults only replace zero values, so values set before decoding are kept
+			if f.defaults != nil && (oldFVal == zeroValue || oldFVal.IsZero()) {
+				if _, err := f.decodeDefault(fVal); err != nil {
+					return true, err
+				}
+				st.defaults++
+				st.meta.addDefault(key, f, p.opts.nesting)
+				continue
+			}
+
+			// nested structs hold their original values along with the defaults applied to their fields
+			if st.defaults > defaults {
+				continue
+			}
+
 			if oldFVal != zeroValue {
 				fVal.Set(oldFVal)
+			} else {
+				fVal.Set(reflect.Zero(f.typ))
 			}
+			st.meta.addUnset(key, f, p.opts.nesting)
 
 			continue
 		}
 
-		err := decodeField(s, fVal)
+		st.meta.addAlias(key, f, p.opts.nesting, fKey)
+		found = true
+		if present != nil {
+			present[i] = true
+		}
+	}
+
+	// the required fields of nested structs are only checked if any of their fields are present
+	if p.required && (found || key == "") {
+		for i, f := range p.fields {
+			if !present[i] && f.isRequired(present) {
+				st.missing = append(st.missing, joinKey(key, f.names[0], p.opts.nesting))
+			}
+		}
+	}
+	return found, nil
+}
+
+// fieldDecoder decodes the parameters stored under the given key as a field of the output struct, and returns the key
+// of the query they were found by, which is empty if none were found. The original value of the field is passed as
+// well, and may be the zero Value. The field must be settable.
+type fieldDecoder func(st *decodeState, key string, old reflect.Value, v reflect.Value) (string, error)
+
+// valuesDecoder decodes a set of parameter strings as a field of the output struct. The value must be settable.
+type valuesDecoder func(s []string, v reflect.Value) error
+
+// valueDecoder decodes a single parameter string as a value. The value must be settable.
+type valueDecoder func(s string, v reflect.Value) error
+
+// fieldDecoder returns the decoder for a field of the given type. Values nested using the given style, as well as
+// arrays and slices, are decoded from several parameters. Other fields are decoded from the set of parameter strings
+// stored under the key of the field.
+func (c *config) fieldDecoder(t reflect.Type, opts fieldOptions) fieldDecoder {
+	if c.isNested(t, opts) || c.isSequence(t) {
+		switch t.Kind() {
+		case reflect.Struct:
+			return func(st *decodeState, key string, old reflect.Value, v reflect.Value) (string, error) {
+				if st.depth >= c.maxDepth {
+					if len(st.segments(nestedPrefix(key, opts.nesting))) == 0 {
+						return "", nil
+					}
+					return key, newDecodeError(ErrMaxDepth,
+						fmt.Sprintf("depth of field '%s' exceeds maximum of %d", key, c.maxDepth), key, nil)
+				}
+
+				p, err := c.structPlan(t, opts)
+				if err != nil {
+					return key, newDecodeError(ErrInvalidTarget,
+						fmt.Sprintf("cannot decode into value of type: %s", t.String()), key, err)
+				}
+
+				st.depth++
+				found, err := c.decodeFields(st, key, old, v, p)
+				st.depth--
+				if !found && err == nil {
+					return "", nil
+				}
+				return key, err
+			}
+		case reflect.Map:
+			return c.mapDecoder(t, opts)
+		default:
+			return c.sequenceDecoder(t, opts)
+		}
+	}
+
+	if t.Kind() == reflect.Ptr && (c.isNested(indirectType(t), opts) || c.isSequence(indirectType(t))) {
+		dec := c.fieldDecoder(t.Elem(), opts)
+		elemKind := indirectType(t).Kind()
+		return func(st *decodeState, key string, old reflect.Value, v reflect.Value) (string, error) {
+			// nested structs and maps are only allocated if any keys are nested under the key, which also ends the
+			// recursion of self-referential types
+			if (elemKind == reflect.Struct || elemKind == reflect.Map) &&
+				len(st.segments(nestedPrefix(key, opts.nesting))) == 0 {
+				return "", nil
+			}
+
+			if old != zeroValue {
+				if old.IsNil() {
+					old = zeroValue
+				} else {
+					old = old.Elem()
+				}
+			}
+
+			newVal := reflect.New(t.Elem())
+			match, err := dec(st, key, old, newVal.Elem())
+			if match != "" {
+				v.Set(newVal)
+			}
+			return match, err
+		}
+	}
+
+	dec := c.valuesDecoder(t, opts)
+	return func(st *decodeState, key string, old reflect.Value, v reflect.Value) (string, error) {
+		s, match, err := st.lookup(key)
+		if err != nil || match == "" {
+			return match, err
+		}
+
+		err = dec(s, v)
 		if err != nil {
-			return newDecodeError(fmt.Sprintf("unable to decode value in field '%s'", tag), tag, err)
+			return match, newValueError(fmt.Sprintf("unable to decode value in field '%s'", match), match, err)
 		}
+		return match, nil
 	}
-	return nil
 }
 
-// decodeField decodes a set of parameter strings as a field of the output struct. Arrays and slices are represented as
-// multiple values. Other values are decoded as a single value.
-func decodeField(s []string, v reflect.Value) error {
-	if len(s) == 0 {
-		return nil
+// mapDecoder returns the decoder for a map nested using the given style. An entry is decoded for each distinct key
+// nested under the key of the map, e.g. `env` for `labels[env]` or `labels.env`. Keys and values are decoded according
+// to their types. The decoded map replaces any previous value.
+func (c *config) mapDecoder(t reflect.Type, opts fieldOptions) fieldDecoder {
+	keyDec := c.valueDecoder(t.Key(), opts)
+	dec := c.lazyFieldDecoder(t.Elem(), opts)
+	return func(st *decodeState, key string, old reflect.Value, v reflect.Value) (string, error) {
+		var m reflect.Value
+		for _, seg := range st.segments(nestedPrefix(key, opts.nesting)) {
+			if seg == "" {
+				continue
+			}
+
+			eKey := joinKey(key, seg, opts.nesting)
+			eVal := reflect.New(t.Elem()).Elem()
+			match, err := dec(st, eKey, zeroValue, eVal)
+			if err != nil {
+				return key, err
+			}
+			if match == "" {
+				continue
+			}
+
+			kVal := reflect.New(t.Key()).Elem()
+			if err := keyDec(seg, kVal); err != nil {
+				return key, newValueError(fmt.Sprintf("unable to decode map key in field '%s'", eKey), eKey,
+					&valueError{value: seg, index: -1, err: err})
+			}
+
+			if m == zeroValue {
+				m = reflect.MakeMap(t)
+			}
+			m.SetMapIndex(kVal, eVal)
+		}
+		if m == zeroValue {
+			return "", nil
+		}
+
+		v.Set(m)
+		return key, nil
 	}
-	switch v.Kind() {
-	case reflect.Array:
-		for i := 0; i < v.Len() && i < len(s); i++ {
-			iVal := v.Index(i)
-			err := decodeValue(s[i], iVal.Addr())
+}
+
+// sequenceDecoder returns the decoder for an array or slice. Elements are decoded from the indexed keys, e.g.
+// `tags[0]`, and placed at their index, when using SliceIndexed, NestingDot or NestingBracket. Indexed elements may be
+// nested themselves. Elements are also decoded from the values of the key itself, split by the delimiter of the slice
+// style if any, and of the append style key `tags[]` when using NestingBracket. These fill the positions without an
+// indexed key in order, and any remaining gaps are left as zero values. Arrays keep as many elements as they fit.
+func (c *config) sequenceDecoder(t reflect.Type, opts fieldOptions) fieldDecoder {
+	valDec := c.valueDecoder(t.Elem(), opts)
+	elemDec := c.lazyFieldDecoder(t.Elem(), opts)
+	delim := opts.slices.delimiter()
+	// indexed keys aren't looked for using the default styles, so decoding doesn't scan the keys of the query
+	indexed := opts.slices == SliceIndexed || opts.nesting != NestingJSON
+	return func(st *decodeState, key string, old reflect.Value, v reflect.Value) (string, error) {
+		s, match, err := st.lookup(key)
+		if err != nil {
+			return key, err
+		}
+		if delim != "" {
+			s = splitDelimited(s, delim)
+		}
+		if opts.nesting == NestingBracket {
+			appended, appendMatch, err := st.lookup(key + "[]")
 			if err != nil {
-				return err
+				return key, err
+			}
+			if appendMatch != "" {
+				s = append(s[:len(s):len(s)], appended...)
+				if match == "" {
+					match = appendMatch
+				}
 			}
 		}
-	case reflect.Slice:
-		sVal := reflect.New(v.Type()).Elem()
-		for i := 0; i < len(s); i++ {
-			iVal := reflect.New(v.Type().Elem())
-			err := decodeValue(s[i], iVal)
+
+		var indices []sliceIndex
+		if indexed && st.hasBrackets() {
+			indices = st.indices(key)
+		}
+		if match == "" {
+			if len(indices) == 0 {
+				return "", nil
+			}
+			match = key
+		}
+
+		// the values of the key itself fill the positions without an indexed key in order, or all positions if there
+		// are no indexed keys
+		n := len(s)
+		var slots []int
+		if len(indices) > 0 {
+			last := indices[len(indices)-1]
+			if last.i < 0 || last.i > c.maxIndex {
+				err := newDecodeError(ErrRange, fmt.Sprintf("index of field '%s' exceeds maximum of %d", key, c.maxIndex),
+					indexKey(key, last.seg), nil)
+				err.value = last.seg
+				return key, err
+			}
+			slots = freeSlots(indices, len(s))
+			n = last.i + 1
+			if len(slots) > 0 && slots[len(slots)-1] >= n {
+				n = slots[len(slots)-1] + 1
+			}
+		}
+
+		var sVal reflect.Value
+		if t.Kind() == reflect.Array {
+			sVal = reflect.New(t).Elem()
+			if n > sVal.Len() {
+				n = sVal.Len()
+			}
+		} else {
+			sVal = reflect.MakeSlice(t, n, n)
+		}
+
+		for _, idx := range indices {
+			if idx.i >= n {
+				break
+			}
+			_, err := elemDec(st, indexKey(key, idx.seg), zeroValue, sVal.Index(idx.i))
 			if err != nil {
-				return err
+				return key, err
+			}
+		}
+
+		for i := range s {
+			slot := i
+			if slots != nil {
+				slot = slots[i]
+			}
+			if slot >= n {
+				break
+			}
+			err := valDec(s[i], sVal.Index(slot))
+			if err != nil {
+				return match, newValueError(fmt.Sprintf("unable to decode value in field '%s'", match), match,
+					&valueError{value: s[i], index: i, err: err})
 			}
-			sVal = reflect.Append(sVal, iVal.Elem())
 		}
+
 		v.Set(sVal)
+		return match, nil
+	}
+}
+
+// valuesDecoder returns the decoder for a field of the given type, which isn't an array or slice. Types with a
+// registered converter are decoded as a single value using it. Types implementing QueryParamUnmarshaler are decoded
+// using it. Other values are decoded as a single value.
+func (c *config) valuesDecoder(t reflect.Type, opts fieldOptions) valuesDecoder {
+	if conv, ok := c.converter(t, opts); ok && conv.decode != nil {
+		return singleValuesDecoder(conv.valueDecoder(t))
+	}
+
+	if implementsUnmarshaler(t, unmarshalerType) {
+		return func(s []string, v reflect.Value) error {
+			return v.Addr().Interface().(QueryParamUnmarshaler).UnmarshalQueryParam(s)
+		}
+	}
+
+	if implementsUnmarshaler(t, textUnmarshalerType) {
+		return singleValuesDecoder(c.valueDecoder(t, opts))
+	}
+
+	switch t.Kind() {
 	case reflect.Ptr:
-		if v.IsNil() {
-			v.Set(reflect.New(v.Type().Elem()))
+		dec := c.valuesDecoder(t.Elem(), opts)
+		return func(s []string, v reflect.Value) error {
+			if v.IsNil() {
+				v.Set(reflect.New(t.Elem()))
+			}
+			return dec(s, v.Elem())
 		}
-		return decodeField(s, v.Elem())
 	default:
-		return decodeValue(s[0], v.Addr())
+		return singleValuesDecoder(c.valueDecoder(t, opts))
 	}
-	return nil
 }
 
-// decodeValue decodes a parameter string as a value. Base types are parsed using `strconv`. Maps and structs are
-// decoded as json objects using standard json unmarshaling. Channels and functions are skipped, as they're not
-// supported.
-func decodeValue(s string, v reflect.Value) error {
-	switch v.Elem().Kind() {
+// splitDelimited splits each of the values using the delimiter, and decodes percent-encoded occurrences of the
+// delimiter and the percent sign within them. Other percent-encoded text is left as is. Empty values are skipped.
+func splitDelimited(s []string, delim string) []string {
+	unescaper := strings.NewReplacer(
+		"%25", "%",
+		percentEncode(delim), delim,
+		strings.ToLower(percentEncode(delim)), delim,
+	)
+
+	var res []string
+	for _, v := range s {
+		if v == "" {
+			continue
+		}
+		for _, part := range strings.Split(v, delim) {
+			res = append(res, unescaper.Replace(part))
+		}
+	}
+	return res
+}
+
+// singleValuesDecoder returns a values decoder using the first of the parameter strings.
+func singleValuesDecoder(dec valueDecoder) valuesDecoder {
+	return func(s []string, v reflect.Value) error {
+		if err := dec(s[0], v); err != nil {
+			return &valueError{value: s[0], index: 0, err: err}
+		}
+		return nil
+	}
+}
+
+// valueDecoder returns the decoder for a single value of the given type. Types with a registered converter are decoded
+// using it, followed by types implementing encoding.TextUnmarshaler. Base types are parsed using `strconv`. Maps and
+// structs are decoded using the configured unmarshal function, json by default. Channels and functions are skipped, as
+// they're not supported.
+func (c *config) valueDecoder(t reflect.Type, opts fieldOptions) valueDecoder {
+	if conv, ok := c.converter(t, opts); ok && conv.decode != nil {
+		return conv.valueDecoder(t)
+	}
+
+	if implementsUnmarshaler(t, textUnmarshalerType) {
+		return func(s string, v reflect.Value) error {
+			return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+
+	switch t.Kind() {
 	case reflect.String:
-		v.Elem().SetString(s)
+		return func(s string, v reflect.Value) error {
+			v.SetString(s)
+			return nil
+		}
 	case reflect.Bool:
-		b, err := strconv.ParseBool(s)
-		if err != nil {
-			return err
+		return func(s string, v reflect.Value) error {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return err
+			}
+			v.SetBool(b)
+			return nil
 		}
-		v.Elem().SetBool(b)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		i, err := strconv.ParseInt(s, 10, 64)
-		if err != nil {
-			return err
+		return func(s string, v reflect.Value) error {
+			i, err := strconv.ParseInt(s, 10, t.Bits())
+			if err != nil {
+				return err
+			}
+			v.SetInt(i)
+			return nil
 		}
-		v.Elem().SetInt(i)
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		i, err := strconv.ParseUint(s, 10, 64)
-		if err != nil {
-			return err
+		return func(s string, v reflect.Value) error {
+			i, err := strconv.ParseUint(s, 10, t.Bits())
+			if err != nil {
+				return err
+			}
+			v.SetUint(i)
+			return nil
 		}
-		v.Elem().SetUint(i)
 	case reflect.Float32, reflect.Float64:
-		f, err := strconv.ParseFloat(s, 64)
-		if err != nil {
-			return err
-		}
-		v.Elem().SetFloat(f)
-	case reflect.Complex64, reflect.Complex128:
-		f, err := strconv.ParseComplex(s, 128)
-		if err != nil {
-			return err
-		}
-		v.Elem().SetComplex(f)
-	case reflect.Map, reflect.Struct:
-		i := v.Interface()
-		switch i.(type) {
-		case *time.Time:
-			t, err := parseTime(s)
+		return func(s string, v reflect.Value) error {
+			f, err := strconv.ParseFloat(s, t.Bits())
 			if err != nil {
 				return err
 			}
-			v.Elem().Set(reflect.ValueOf(t))
-		default:
-			err := json.Unmarshal([]byte(s), i)
+			v.SetFloat(f)
+			return nil
+		}
+	case reflect.Complex64, reflect.Complex128:
+		return func(s string, v reflect.Value) error {
+			f, err := strconv.ParseComplex(s, t.Bits())
 			if err != nil {
 				return err
 			}
+			v.SetComplex(f)
+			return nil
+		}
+	case reflect.Map, reflect.Struct:
+		return func(s string, v reflect.Value) error {
+			return c.unmarshal([]byte(s), v.Addr().Interface())
+		}
+	case reflect.Ptr:
+		dec := c.valueDecoder(t.Elem(), opts)
+		return func(s string, v reflect.Value) error {
+			if v.IsNil() {
+				v.Set(reflect.New(t.Elem()))
+			}
+			return dec(s, v.Elem())
 		}
 	case reflect.Chan, reflect.Func:
+		return func(s string, v reflect.Value) error {
+			return nil
+		}
 	default:
-		return fmt.Errorf("unsupported field kind: %s", v.Elem().Kind().String())
+		return func(s string, v reflect.Value) error {
+			return fmt.Errorf("%w: field kind %s", ErrUnsupportedType, t.Kind().String())
+		}
 	}
-	return nil
 }
 
-// parseTime parses a string as time.Time. It supports the RFC3339 format, unix seconds, and json marshalled time.Time
-// structs.
-func parseTime(s string) (time.Time, error) {
+// parseTime parses a string as time.Time using the given format. Only the default format, RFC3339, falls back to unix
+// seconds and json marshalled time.Time structs, as other formats are chosen explicitly. Times without a time zone are
+// parsed in the given location. If it's nil, layouts are parsed in UTC and unix times in the local time zone, as by
+// time.Parse and time.Unix.
+func parseTime(s string, format string, loc *time.Location) (time.Time, error) {
+	switch format {
+	case unixTimeFormat:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return unixTime(f, 1, loc), nil
+		}
+		return time.Time{}, fmt.Errorf("invalid unix time '%s'", s)
+	case unixMilliTimeFormat:
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return unixTime(f, 1e3, loc), nil
+		}
+		return time.Time{}, fmt.Errorf("invalid unix milliseconds '%s'", s)
+	case time.RFC3339Nano:
+	default:
+		layoutLoc := loc
+		if layoutLoc == nil {
+			layoutLoc = time.UTC
+		}
+		return time.ParseInLocation(format, s, layoutLoc)
+	}
+
 	// attempt to parse time as RFC3339 string
 	t, err := time.Parse(time.RFC3339Nano, s)
 	if err == nil {
@@ -236,14 +909,27 @@ func parseTime(s string) (time.Time, error) {
 
 	// attempt to parse time as float number of unix seconds
 	if f, err := strconv.ParseFloat(s, 64); err == nil {
-		sec, dec := math.Modf(f)
-		return time.Unix(int64(sec), int64(dec*(1e9))), nil
+		return unixTime(f, 1, loc), nil
 	}
 
 	// attempt to parse time as json marshaled value
-	if err := json.Unmarshal([]byte(s), &t); err == nil {
-		return t, nil
+	var jsonTime time.Time
+	if err := json.Unmarshal([]byte(s), &jsonTime); err == nil {
+		return jsonTime, nil
 	}
 
 	return time.Time{}, err
 }
+
+// unixTime returns the time of a number of units since the unix epoch, with the given number of units per second. The
+// time is in the given location, or the local time zone if it's nil.
+func unixTime(f float64, unitsPerSecond int64, loc *time.Location) time.Time {
+	units, dec := math.Modf(f)
+	nsecPerUnit := int64(time.Second) / unitsPerSecond
+	sec, rem := int64(units)/unitsPerSecond, int64(units)%unitsPerSecond
+	t := time.Unix(sec, rem*nsecPerUnit+int64(dec*float64(nsecPerUnit)))
+	if loc != nil {
+		t = t.In(loc)
+	}
+	return t
+}
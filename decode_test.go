@@ -1,8 +1,12 @@
 package mapqueryparam_test
 
 import (
+	"errors"
+	"net"
 	"net/url"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -387,6 +391,151 @@ func TestDecodeTime(t *testing.T) {
 	}
 }
 
+func TestTimeFormat_RoundTrip(t *testing.T) {
+	type Range struct {
+		Until time.Time `mqp:"until"`
+	}
+	type S struct {
+		Since   time.Time   `mqp:"since,time=2006-01-02"`
+		Created time.Time   `mqp:"created,time=unix"`
+		Updated *time.Time  `mqp:"updated,time=unixmilli"`
+		Expires time.Time   `mqp:"expires,time=rfc1123"`
+		Dates   []time.Time `mqp:"dates,slice=comma,time=dateonly"`
+		Range   Range       `mqp:"range,nested=dot,time=unixmilli"`
+		Default time.Time   `mqp:"default"`
+	}
+
+	updated := time.Date(2021, 3, 4, 5, 6, 7, 123000000, time.UTC)
+	want := S{
+		Since:   time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+		Created: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Updated: &updated,
+		Expires: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+		Dates:   []time.Time{time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)},
+		Range:   Range{Until: time.Date(2021, 3, 4, 5, 6, 7, 1000000, time.UTC)},
+		Default: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+
+	query, err := mapqueryparam.Encode(want)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	wantQuery := map[string][]string{
+		"since":       {"2021-03-04"},
+		"created":     {"1614834367"},
+		"updated":     {"1614834367123"},
+		"expires":     {"Thu, 04 Mar 2021 05:06:07 UTC"},
+		"dates":       {"2021-03-04,2021-03-05"},
+		"range.until": {"1614834367001"},
+		"default":     {"2021-03-04T05:06:07Z"},
+	}
+	if !reflect.DeepEqual(query, wantQuery) {
+		t.Errorf("Encode() got = %v, want %v", query, wantQuery)
+	}
+
+	var got S
+	if err := mapqueryparam.NewDecoder(mapqueryparam.WithTimeLocation(time.UTC)).Decode(query, &got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() got = %v, want %v", got, want)
+	}
+
+	// without a location, unix times are decoded in the local time zone, as by time.Unix
+	got = S{}
+	if err := mapqueryparam.Decode(query, &got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !got.Created.Equal(want.Created) || got.Created.Location() != time.Local || got.Since.Location() != time.UTC {
+		t.Errorf("Decode() got = %v and %v, want local unix time and UTC date", got.Created, got.Since)
+	}
+
+	err = mapqueryparam.Decode(map[string][]string{"since": {"04/03/2021"}}, &got)
+	if !errors.Is(err, mapqueryparam.ErrSyntax) || !strings.Contains(err.Error(), "2006") {
+		t.Errorf("Decode() error = %v, want error of layout", err)
+	}
+}
+
+func TestTimeFormat_Options(t *testing.T) {
+	type S struct {
+		Date  time.Time `mqp:"date"`
+		Stamp time.Time `mqp:"stamp,time=rfc3339"`
+	}
+
+	loc := time.FixedZone("CET", 3600)
+	opts := []mapqueryparam.Option{
+		mapqueryparam.WithTimeLayout("datetime"),
+		mapqueryparam.WithTimeLocation(loc),
+	}
+
+	v := S{
+		Date:  time.Date(2021, 3, 4, 23, 30, 0, 0, time.UTC),
+		Stamp: time.Date(2021, 3, 4, 23, 30, 0, 0, time.UTC),
+	}
+	query, err := mapqueryparam.NewEncoder(opts...).Encode(v)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	wantQuery := map[string][]string{"date": {"2021-03-05 00:30:00"}, "stamp": {"2021-03-05T00:30:00+01:00"}}
+	if !reflect.DeepEqual(query, wantQuery) {
+		t.Errorf("Encode() got = %v, want %v", query, wantQuery)
+	}
+
+	var got S
+	if err := mapqueryparam.NewDecoder(opts...).Decode(query, &got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !got.Date.Equal(v.Date) || got.Date.Location() != loc || !got.Stamp.Equal(v.Stamp) {
+		t.Errorf("Decode() got = %v, want %v", got, v)
+	}
+
+	// custom converters take precedence over the time format
+	dec := mapqueryparam.NewDecoder(mapqueryparam.WithConverter(reflect.TypeOf(time.Time{}), nil,
+		func(s string) (interface{}, error) {
+			return time.Time{}, nil
+		}))
+	if err := dec.Decode(map[string][]string{"stamp": {"x"}}, &got); err != nil || !got.Stamp.IsZero() {
+		t.Errorf("Decode() got = %v, error = %v, want converted value", got.Stamp, err)
+	}
+}
+
+func TestTimeFormat_Strict(t *testing.T) {
+	type S struct {
+		Default time.Time `mqp:"default"`
+		Date    time.Time `mqp:"date,time=2006-01-02"`
+		Unix    time.Time `mqp:"unix,time=unix"`
+		Milli   time.Time `mqp:"milli,time=unixmilli"`
+	}
+
+	tests := []struct {
+		name    string
+		opts    []mapqueryparam.Option
+		query   map[string][]string
+		wantErr bool
+	}{
+		{"DefaultRFC3339", nil, map[string][]string{"default": {"2021-03-04T00:00:00Z"}}, false},
+		{"DefaultUnix", nil, map[string][]string{"default": {"1614816000"}}, false},
+		{"DefaultJSON", nil, map[string][]string{"default": {`"2021-03-04T00:00:00Z"`}}, false},
+		{"LayoutUnix", nil, map[string][]string{"date": {"2024"}}, true},
+		{"LayoutRFC3339", nil, map[string][]string{"date": {"2021-03-04T00:00:00Z"}}, true},
+		{"UnixRFC3339", nil, map[string][]string{"unix": {"2021-03-04T00:00:00Z"}}, true},
+		{"UnixMilliRFC3339", nil, map[string][]string{"milli": {"2021-03-04T00:00:00Z"}}, true},
+		{"GlobalLayout", []mapqueryparam.Option{mapqueryparam.WithTimeLayout("dateonly")},
+			map[string][]string{"default": {"1614816000"}}, true},
+		{"GlobalUnix", []mapqueryparam.Option{mapqueryparam.WithTimeLayout("unix")},
+			map[string][]string{"default": {"2021-03-04T00:00:00Z"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got S
+			err := mapqueryparam.NewDecoder(tt.opts...).Decode(tt.query, &got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestDecode_KeepOldValues(t *testing.T) {
 	type S struct {
 		A string
@@ -406,3 +555,968 @@ func TestDecode_KeepOldValues(t *testing.T) {
 		t.Errorf("Encode() got = %v, want %v", s, want)
 	}
 }
+
+func TestDecoder(t *testing.T) {
+	type S struct {
+		A string `q:"a"`
+		C time.Time
+		D struct{ E string }
+	}
+
+	dec := mapqueryparam.NewDecoder(
+		mapqueryparam.WithTagName("q"),
+		mapqueryparam.WithTimeLayout("2006-01-02"),
+		mapqueryparam.WithUnmarshalFunc(func(data []byte, v interface{}) error {
+			v.(*struct{ E string }).E = string(data)
+			return nil
+		}),
+	)
+
+	var s S
+	err := dec.DecodeValues(url.Values{"a": {"foo"}, "C": {"2021-03-04"}, "D": {"bar"}}, &s)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	want := S{A: "foo", C: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), D: struct{ E string }{"bar"}}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("Decode() got = %v, want %v", s, want)
+	}
+}
+
+func TestDecode_Concurrent(t *testing.T) {
+	type S struct {
+		A string
+		B []int
+	}
+
+	want := S{A: "a", B: []int{1, 2}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var s S
+			if err := mapqueryparam.Decode(map[string][]string{"A": {"a"}, "B": {"1", "2"}}, &s); err != nil {
+				t.Errorf("decode failed: %s", err)
+			}
+			if !reflect.DeepEqual(s, want) {
+				t.Errorf("Decode() got = %v, want %v", s, want)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDecode_TextUnmarshaler(t *testing.T) {
+	type S struct {
+		Status   textStatus
+		Statuses []textStatus
+		Point    textPoint
+		PointPtr *textPoint
+		IP       net.IP
+	}
+
+	query := map[string][]string{
+		"Status":   {"inactive"},
+		"Statuses": {"active", "inactive"},
+		"Point":    {"1:2"},
+		"PointPtr": {"3:4"},
+		"IP":       {"10.0.0.1"},
+	}
+
+	var s S
+	if err := mapqueryparam.Decode(query, &s); err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+
+	want := S{
+		Status:   2,
+		Statuses: []textStatus{1, 2},
+		Point:    textPoint{1, 2},
+		PointPtr: &textPoint{3, 4},
+		IP:       net.IPv4(10, 0, 0, 1),
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("Decode() got = %v, want %v", s, want)
+	}
+
+	if err := mapqueryparam.Decode(map[string][]string{"Status": {"unknown"}}, &s); err == nil {
+		t.Errorf("Decode() expected error for unknown status")
+	}
+}
+
+func TestDecode_QueryParamUnmarshaler(t *testing.T) {
+	type S struct {
+		Box    boundingBox `mqp:"bbox"`
+		BoxPtr *boundingBox
+	}
+
+	var s S
+	err := mapqueryparam.Decode(map[string][]string{"bbox": {"1", "2", "3", "4"}, "BoxPtr": {"5", "6", "7", "8"}}, &s)
+	if err != nil {
+		t.Fatalf("decode failed: %s", err)
+	}
+
+	want := S{Box: boundingBox{1, 2, 3, 4}, BoxPtr: &boundingBox{5, 6, 7, 8}}
+	if !reflect.DeepEqual(s, want) {
+		t.Errorf("Decode() got = %v, want %v", s, want)
+	}
+
+	if err := mapqueryparam.Decode(map[string][]string{"bbox": {"1", "2"}}, &s); err == nil {
+		t.Errorf("Decode() expected error for incomplete bounding box")
+	}
+}
+
+func TestDecode_Skip(t *testing.T) {
+	type S struct {
+		A          string `mqp:"-"`
+		B          string `json:"-"`
+		C          string `json:"-,"`
+		EncodeOnly string `mqp:"encode,encodeonly"`
+		DecodeOnly string `mqp:"decode,decodeonly"`
+	}
+
+	v := S{A: "a", EncodeOnly: "x"}
+	query := map[string][]string{"-": {"c"}, "A": {"1"}, "B": {"2"}, "encode": {"3"}, "decode": {"4"}}
+	if err := mapqueryparam.Decode(query, &v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := S{A: "a", C: "c", EncodeOnly: "x", DecodeOnly: "4"}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v, want)
+	}
+
+	type Invalid struct {
+		A string `mqp:"a,encodeonly,decodeonly"`
+	}
+	if err := mapqueryparam.Decode(query, &Invalid{}); err == nil {
+		t.Errorf("Decode() expected error for conflicting options")
+	}
+}
+
+func TestDecode_Required(t *testing.T) {
+	type Page struct {
+		Limit  int `mqp:"limit,required"`
+		Offset int `mqp:"offset"`
+	}
+	type Range struct {
+		Start string `mqp:"start"`
+		End   string `mqp:"end,required_with=start"`
+	}
+	type S struct {
+		Range
+		UserID int    `mqp:"user_id,required"`
+		Name   string `mqp:"name,n,required"`
+		Page   *Page  `mqp:"page,nested=dot"`
+	}
+
+	tests := []struct {
+		name    string
+		query   map[string][]string
+		missing []string
+	}{
+		{"Present", map[string][]string{"user_id": {"1"}, "n": {"x"}}, nil},
+		{"Missing", map[string][]string{}, []string{"user_id", "name"}},
+		{"Embedded", map[string][]string{"user_id": {"1"}, "name": {"x"}, "start": {"a"}}, []string{"end"}},
+		{"Nested", map[string][]string{"user_id": {"1"}, "name": {"x"}, "page.limit": {"1"}}, nil},
+		{
+			name:    "NestedMissing",
+			query:   map[string][]string{"page.offset": {"1"}, "start": {"a"}},
+			missing: []string{"page.limit", "end", "user_id", "name"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v S
+			err := mapqueryparam.Decode(tt.query, &v)
+			if tt.missing == nil {
+				if err != nil {
+					t.Errorf("Decode() error = %v", err)
+				}
+				return
+			}
+
+			var decodeErr mapqueryparam.DecodeError
+			if !errors.As(err, &decodeErr) || !reflect.DeepEqual(decodeErr.Missing(), tt.missing) {
+				t.Errorf("Decode() error = %v, want missing %v", err, tt.missing)
+			}
+		})
+	}
+
+	type Invalid struct {
+		A string `mqp:"a,required_with=b"`
+	}
+	if err := mapqueryparam.Decode(map[string][]string{}, &Invalid{}); err == nil {
+		t.Errorf("Decode() expected error for unknown parameter")
+	}
+
+	// flags in the first position of the tag are names
+	type Named struct {
+		Required string `mqp:"required"`
+		Remain   string `mqp:"remain,omitempty"`
+	}
+	var named Named
+	if err := mapqueryparam.Decode(map[string][]string{"remain": {"x"}}, &named); err != nil || named.Remain != "x" {
+		t.Errorf("Decode() got = %+v, error = %v, want field named remain", named, err)
+	}
+}
+
+func TestDecode_Default(t *testing.T) {
+	type S struct {
+		Limit  int        `mqp:"limit,default=20"`
+		Sort   []string   `mqp:"sort,default=name|age"`
+		IDs    []int      `mqp:"ids,slice=comma,default=1|2"`
+		Since  time.Time  `mqp:"since,default=2021-03-04T00:00:00Z"`
+		Active *bool      `mqp:"active,default=true"`
+		Status textStatus `mqp:"status,default=inactive"`
+	}
+
+	var v S
+	if err := mapqueryparam.Decode(map[string][]string{"limit": {"5"}}, &v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	active := true
+	want := S{
+		Limit:  5,
+		Sort:   []string{"name", "age"},
+		IDs:    []int{1, 2},
+		Since:  time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC),
+		Active: &active,
+		Status: 2,
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v, want)
+	}
+
+	// defaults aren't shared between decoded values, and don't replace existing values
+	v.Sort[0] = "x"
+	v2 := S{Limit: 3}
+	if err := mapqueryparam.Decode(map[string][]string{}, &v2); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want.Limit = 3
+	if !reflect.DeepEqual(v2, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v2, want)
+	}
+
+	// defaults of nested structs are applied even if none of their fields are present
+	type Inner struct {
+		Limit int    `mqp:"limit,default=20"`
+		Name  string `mqp:"name"`
+	}
+	type Outer struct {
+		In Inner `mqp:"in,nested=dot"`
+	}
+	outer := Outer{In: Inner{Name: "x"}}
+	meta, err := mapqueryparam.DecodeWithMetadata(map[string][]string{}, &outer)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if wantOuter := (Outer{In: Inner{Limit: 20, Name: "x"}}); !reflect.DeepEqual(outer, wantOuter) {
+		t.Errorf("Decode() got = %+v, want %+v", outer, wantOuter)
+	}
+	if !reflect.DeepEqual(meta.Defaults, []string{"in.limit"}) || !reflect.DeepEqual(meta.Unset, []string{"in.name"}) {
+		t.Errorf("Decode() got defaults = %v, unset = %v, want [in.limit] and [in.name]", meta.Defaults, meta.Unset)
+	}
+
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{"InvalidValue", &struct {
+			A int `mqp:"a,default=x"`
+		}{}},
+		{"Required", &struct {
+			A int `mqp:"a,required,default=1"`
+		}{}},
+		{"Nested", &struct {
+			A struct{ B int } `mqp:"a,nested=dot,default=1"`
+		}{}},
+		{"Constraint", &struct {
+			A int `mqp:"a,default=500,max=100"`
+		}{}},
+		{"ConstraintElements", &struct {
+			A []string `mqp:"a,default=x|z,oneof=x|y"`
+		}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := mapqueryparam.Decode(map[string][]string{}, tt.v); err == nil {
+				t.Errorf("Decode() expected error for invalid default")
+			}
+		})
+	}
+}
+
+func TestDecode_Validation(t *testing.T) {
+	type S struct {
+		Limit  int        `mqp:"limit,l,min=1,max=100"`
+		Code   string     `mqp:"code,len=3,pattern=^[A-Z]+$"`
+		Name   *string    `mqp:"name,min=2,max=4"`
+		Sort   []string   `mqp:"sort,oneof=name|age,maxitems=2"`
+		Status textStatus `mqp:"status,oneof=active"`
+		Scores []float64  `mqp:"scores,slice=comma,min=0,max=1"`
+	}
+
+	query := map[string][]string{
+		"limit":  {"10"},
+		"code":   {"ABC"},
+		"name":   {"abc"},
+		"sort":   {"name", "age"},
+		"status": {"active"},
+		"scores": {"0.5,1"},
+	}
+	var v S
+	if err := mapqueryparam.Decode(query, &v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query map[string][]string
+		field string
+	}{
+		{"Min", map[string][]string{"limit": {"0"}}, "limit"},
+		{"MaxAlias", map[string][]string{"l": {"101"}}, "l"},
+		{"Len", map[string][]string{"code": {"ABCD"}}, "code"},
+		{"Pattern", map[string][]string{"code": {"abc"}}, "code"},
+		{"MinLength", map[string][]string{"name": {"a"}}, "name"},
+		{"MaxLength", map[string][]string{"name": {"abcde"}}, "name"},
+		{"OneOf", map[string][]string{"sort": {"name", "email"}}, "sort"},
+		{"MaxItems", map[string][]string{"sort": {"name", "age", "name"}}, "sort"},
+		{"OneOfText", map[string][]string{"status": {"inactive"}}, "status"},
+		{"Elements", map[string][]string{"scores": {"0.5,2"}}, "scores"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v S
+			err := mapqueryparam.Decode(tt.query, &v)
+			var decodeErr mapqueryparam.DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.Field() != tt.field {
+				t.Errorf("Decode() error = %v, want error for field %s", err, tt.field)
+			}
+		})
+	}
+
+	invalid := []struct {
+		name string
+		v    interface{}
+	}{
+		{"MinType", &struct {
+			A bool `mqp:"a,min=1"`
+		}{}},
+		{"MaxItemsType", &struct {
+			A int `mqp:"a,maxitems=1"`
+		}{}},
+		{"Pattern", &struct {
+			A string `mqp:"a,pattern=["`
+		}{}},
+		{"OneOfValue", &struct {
+			A int `mqp:"a,oneof=1|x"`
+		}{}},
+		{"PatternComma", &struct {
+			A string `mqp:"a,pattern=^[a-z]{2,3}$"`
+		}{}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := mapqueryparam.Decode(map[string][]string{}, tt.v); err == nil {
+				t.Errorf("Decode() expected error for invalid constraint")
+			}
+		})
+	}
+}
+
+func TestDecode_CollectErrors(t *testing.T) {
+	type Inner struct {
+		Age int `mqp:"age"`
+	}
+	type S struct {
+		A     int    `mqp:"a"`
+		B     bool   `mqp:"b"`
+		C     string `mqp:"c,required"`
+		D     int    `mqp:"d,max=10"`
+		E     int    `mqp:"e"`
+		Inner Inner  `mqp:"inner,nested=dot"`
+	}
+
+	query := map[string][]string{"a": {"x"}, "b": {"y"}, "d": {"11"}, "e": {"1"}, "inner.age": {"z"}}
+	dec := mapqueryparam.NewDecoder(mapqueryparam.WithCollectErrors(true))
+
+	v := S{E: 5}
+	err := dec.Decode(query, &v)
+	var decodeErrs mapqueryparam.DecodeErrors
+	if !errors.As(err, &decodeErrs) {
+		t.Fatalf("Decode() error = %v, want DecodeErrors", err)
+	}
+
+	var fields []string
+	for _, decodeErr := range decodeErrs {
+		fields = append(fields, decodeErr.Field())
+	}
+	want := []string{"a", "b", "d", "inner.age", "c"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("Decode() error fields = %v, want %v", fields, want)
+	}
+	if len(decodeErrs.Unwrap()) != len(want) {
+		t.Errorf("Unwrap() got %d errors, want %d", len(decodeErrs.Unwrap()), len(want))
+	}
+	if v.E != 5 {
+		t.Errorf("Decode() modified value on error, got = %+v", v)
+	}
+
+	err = dec.Decode(map[string][]string{"a": {"1"}, "c": {"x"}}, &v)
+	if err != nil {
+		t.Errorf("Decode() error = %v", err)
+	}
+}
+
+func TestDecode_Strict(t *testing.T) {
+	type Embedded struct {
+		Page int `mqp:"page"`
+	}
+	type S struct {
+		Embedded
+		Name  string            `mqp:"name,n"`
+		Tags  []string          `mqp:"tags,nested=bracket"`
+		Meta  map[string]string `mqp:"meta,nested=dot"`
+		Token string            `mqp:"-"`
+	}
+
+	dec := mapqueryparam.NewDecoder(
+		mapqueryparam.WithStrict(true),
+		mapqueryparam.WithAllowedParameters("utm_*", "_"),
+	)
+
+	tests := []struct {
+		name    string
+		query   map[string][]string
+		unknown []string
+	}{
+		{
+			name: "Known",
+			query: map[string][]string{
+				"page": {"1"}, "n": {"x"}, "tags[]": {"a"}, "tags[1]": {"b"}, "meta.k": {"v"}, "utm_source": {"x"},
+				"_": {"1"}, "empty": {},
+			},
+		},
+		{"Unknown", map[string][]string{"pgae": {"2"}, "name": {"x"}, "Token": {"x"}}, []string{"Token", "pgae"}},
+		{"UnusedAlias", map[string][]string{"name": {"x"}, "n": {"y"}}, []string{"n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v S
+			err := dec.Decode(tt.query, &v)
+			if tt.unknown == nil {
+				if err != nil {
+					t.Errorf("Decode() error = %v", err)
+				}
+				return
+			}
+
+			var decodeErr mapqueryparam.DecodeError
+			if !errors.As(err, &decodeErr) || !errors.Is(err, mapqueryparam.ErrUnknownParameter) ||
+				!reflect.DeepEqual(decodeErr.Unknown(), tt.unknown) {
+				t.Errorf("Decode() error = %v, want unknown %v", err, tt.unknown)
+			}
+		})
+	}
+
+	var v S
+	if err := mapqueryparam.Decode(map[string][]string{"pgae": {"2"}}, &v); err != nil {
+		t.Errorf("Decode() error = %v, want unknown parameters to be ignored by default", err)
+	}
+}
+
+func TestDecode_Remain(t *testing.T) {
+	type Embedded struct {
+		Page int `mqp:"page"`
+	}
+	type S struct {
+		Embedded
+		Name  string     `mqp:"name"`
+		Extra url.Values `mqp:",remain"`
+	}
+
+	query := map[string][]string{"page": {"1"}, "name": {"x"}, "utm_source": {"a"}, "b": {"1", "2"}}
+	var v S
+	if err := mapqueryparam.NewDecoder(mapqueryparam.WithStrict(true)).Decode(query, &v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := S{Embedded{1}, "x", url.Values{"utm_source": {"a"}, "b": {"1", "2"}}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v, want)
+	}
+
+	if err := mapqueryparam.Decode(map[string][]string{"name": {"y"}}, &v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if v.Extra != nil {
+		t.Errorf("Decode() got = %+v, want no remaining parameters", v.Extra)
+	}
+
+	invalid := []struct {
+		name string
+		v    interface{}
+	}{
+		{"Type", &struct {
+			A map[string]string `mqp:",remain"`
+		}{}},
+		{"Duplicate", &struct {
+			A url.Values          `mqp:",remain"`
+			B map[string][]string `mqp:",remain"`
+		}{}},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := mapqueryparam.Decode(query, tt.v); err == nil {
+				t.Errorf("Decode() expected error for invalid remain field")
+			}
+		})
+	}
+}
+
+func TestDecode_Normalized(t *testing.T) {
+	type Filter struct {
+		MinAge int `mqp:"min_age"`
+	}
+	type S struct {
+		PageSize int               `mqp:"page_size"`
+		Filter   Filter            `mqp:"filter,nested=dot"`
+		Labels   map[string]string `mqp:"labels,nested=bracket"`
+	}
+
+	tests := []struct {
+		name  string
+		opts  []mapqueryparam.Option
+		query map[string][]string
+		want  S
+		err   error
+	}{
+		{
+			name:  "Exact",
+			query: map[string][]string{"PageSize": {"1"}, "page_size": {"2"}},
+			want:  S{PageSize: 2},
+		},
+		{
+			name:  "CaseInsensitive",
+			opts:  []mapqueryparam.Option{mapqueryparam.WithCaseInsensitive(true)},
+			query: map[string][]string{"PAGE_SIZE": {"1"}, "Filter.Min_Age": {"3"}, "LABELS[Env]": {"prod"}},
+			want:  S{PageSize: 1, Filter: Filter{MinAge: 3}, Labels: map[string]string{"Env": "prod"}},
+		},
+		{
+			name:  "IgnoreSeparators",
+			opts:  []mapqueryparam.Option{mapqueryparam.WithIgnoreSeparators(true)},
+			query: map[string][]string{"page-size": {"1"}, "PageSize": {"2"}, "filter.minage": {"3"}},
+			want:  S{PageSize: 1, Filter: Filter{MinAge: 3}},
+		},
+		{
+			name: "Both",
+			opts: []mapqueryparam.Option{
+				mapqueryparam.WithCaseInsensitive(true),
+				mapqueryparam.WithIgnoreSeparators(true),
+			},
+			query: map[string][]string{"PageSize": {"1"}, "pagesize": {"1"}},
+			want:  S{PageSize: 1},
+		},
+		{
+			name: "Conflict",
+			opts: []mapqueryparam.Option{
+				mapqueryparam.WithCaseInsensitive(true),
+				mapqueryparam.WithIgnoreSeparators(true),
+			},
+			query: map[string][]string{"PageSize": {"1"}, "page_size": {"2"}},
+			err:   mapqueryparam.ErrConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v S
+			err := mapqueryparam.NewDecoder(tt.opts...).Decode(tt.query, &v)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("Decode() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(v, tt.want) {
+				t.Errorf("Decode() got = %+v, want %+v", v, tt.want)
+			}
+		})
+	}
+
+	// errors and metadata name the keys sent rather than the names of the fields
+	dec := mapqueryparam.NewDecoder(mapqueryparam.WithCaseInsensitive(true), mapqueryparam.WithIgnoreSeparators(true))
+
+	var v S
+	err := dec.Decode(map[string][]string{"PAGESIZE": {"x"}}, &v)
+	var decodeErr mapqueryparam.DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Field() != "PAGESIZE" {
+		t.Errorf("Decode() error = %v, want error for field PAGESIZE", err)
+	}
+
+	meta, err := dec.DecodeWithMetadata(map[string][]string{"Page-Size": {"1"}, "FILTER.minAge": {"3"}}, &v)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if meta.Aliases["page_size"] != "Page-Size" || meta.Aliases["filter.min_age"] != "FILTER.minAge" {
+		t.Errorf("Decode() got aliases = %v, want keys sent", meta.Aliases)
+	}
+}
+
+func TestDecode_Recursive(t *testing.T) {
+	type Node struct {
+		Name     string           `mqp:"name"`
+		Next     *Node            `mqp:"next"`
+		Children []Node           `mqp:"children"`
+		Index    map[string]*Node `mqp:"index"`
+	}
+
+	dec := mapqueryparam.NewDecoder(mapqueryparam.WithNesting(mapqueryparam.NestingBracket))
+
+	query := map[string][]string{
+		"name":                 {"a"},
+		"next[name]":           {"b"},
+		"next[next][name]":     {"c"},
+		"children[0][name]":    {"d"},
+		"index[x][next][name]": {"e"},
+	}
+	var v Node
+	if err := dec.Decode(query, &v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := Node{
+		Name:     "a",
+		Next:     &Node{Name: "b", Next: &Node{Name: "c"}},
+		Children: []Node{{Name: "d"}},
+		Index:    map[string]*Node{"x": {Next: &Node{Name: "e"}}},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v, want)
+	}
+
+	deep := "next" + strings.Repeat("[next]", 40) + "[name]"
+	err := dec.Decode(map[string][]string{deep: {"x"}}, &v)
+	if !errors.Is(err, mapqueryparam.ErrMaxDepth) {
+		t.Errorf("Decode() error = %v, want ErrMaxDepth", err)
+	}
+}
+
+func TestDecode_Panic(t *testing.T) {
+	type S struct {
+		Value panicText `mqp:"value"`
+	}
+
+	var v S
+	err := mapqueryparam.Decode(map[string][]string{"value": {"x"}}, &v)
+	if !errors.Is(err, mapqueryparam.ErrInternal) {
+		t.Errorf("Decode() error = %v, want ErrInternal", err)
+	}
+
+	var nilPtr *S
+	err = mapqueryparam.Decode(map[string][]string{}, nilPtr)
+	if !errors.Is(err, mapqueryparam.ErrInvalidTarget) {
+		t.Errorf("Decode() error = %v, want ErrInvalidTarget", err)
+	}
+}
+
+func TestDecode_Nesting(t *testing.T) {
+	type Inner struct {
+		Name string `json:"name"`
+		Age  int    `mqp:"age"`
+	}
+	type Middle struct {
+		Inner  Inner  `mqp:"inner,i"`
+		Ptr    *Inner `mqp:"ptr"`
+		Nil    *Inner `mqp:"nil"`
+		AsJSON Inner  `mqp:"json,nested=json"`
+	}
+	type Root struct {
+		Filter Middle `mqp:"filter"`
+		Other  Inner  `mqp:"other"`
+	}
+
+	query := map[string][]string{
+		"filter.i.name":   {"x"},
+		"filter.i.age":    {"3"},
+		"filter.ptr.name": {"y"},
+		"filter.json":     {`{"name":"z"}`},
+	}
+
+	v := Root{Other: Inner{Name: "w"}, Filter: Middle{Inner: Inner{Name: "old", Age: 1}, AsJSON: Inner{Age: 2}}}
+	err := mapqueryparam.NewDecoder(mapqueryparam.WithNesting(mapqueryparam.NestingDot)).Decode(query, &v)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	want := Root{
+		Filter: Middle{
+			Inner:  Inner{Name: "x", Age: 3},
+			Ptr:    &Inner{Name: "y"},
+			AsJSON: Inner{Name: "z"},
+		},
+		Other: Inner{Name: "w"},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v, want)
+	}
+
+	err = mapqueryparam.NewDecoder(mapqueryparam.WithNesting(mapqueryparam.NestingDot)).
+		Decode(map[string][]string{"filter.inner.age": {"x"}}, &v)
+	var decodeErr mapqueryparam.DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Field() != "filter.inner.age" {
+		t.Errorf("Decode() error = %v, want error for field filter.inner.age", err)
+	}
+}
+
+func TestDecode_Brackets(t *testing.T) {
+	type Address struct {
+		City string `mqp:"city"`
+	}
+	type User struct {
+		Name      string              `mqp:"name"`
+		Tags      []string            `mqp:"tags"`
+		Indexed   []int               `mqp:"indexed"`
+		Meta      map[string]string   `mqp:"meta"`
+		Multi     map[string][]string `mqp:"multi"`
+		Addresses []Address           `mqp:"addresses"`
+		Home      *Address            `mqp:"home"`
+	}
+	type Request struct {
+		User User   `mqp:"user"`
+		IDs  [2]int `mqp:"ids"`
+		Sort []string
+	}
+
+	query := map[string][]string{
+		"user[name]":               {"x"},
+		"user[tags][]":             {"a", "b"},
+		"user[indexed][2]":         {"3"},
+		"user[indexed][0]":         {"1"},
+		"user[indexed][1]":         {"2"},
+		"user[meta][k]":            {"v"},
+		"user[multi][k][]":         {"v1", "v2"},
+		"user[addresses][1][city]": {"c2"},
+		"user[addresses][0][city]": {"c1"},
+		"user[home][city]":         {"c3"},
+		"ids":                      {"1"},
+		"ids[1]":                   {"2"},
+		"Sort[]":                   {"name"},
+	}
+
+	var v Request
+	err := mapqueryparam.NewDecoder(mapqueryparam.WithNesting(mapqueryparam.NestingBracket)).Decode(query, &v)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	want := Request{
+		User: User{
+			Name:      "x",
+			Tags:      []string{"a", "b"},
+			Indexed:   []int{1, 2, 3},
+			Meta:      map[string]string{"k": "v"},
+			Multi:     map[string][]string{"k": {"v1", "v2"}},
+			Addresses: []Address{{City: "c1"}, {City: "c2"}},
+			Home:      &Address{City: "c3"},
+		},
+		IDs:  [2]int{1, 2},
+		Sort: []string{"name"},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v, want)
+	}
+
+	err = mapqueryparam.NewDecoder(mapqueryparam.WithNesting(mapqueryparam.NestingBracket)).
+		Decode(map[string][]string{"user[addresses][0][city]": {"c1"}, "ids[0]": {"x"}}, &v)
+	var decodeErr mapqueryparam.DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Field() != "ids[0]" {
+		t.Errorf("Decode() error = %v, want error for field ids[0]", err)
+	}
+}
+
+func TestDecode_Maps(t *testing.T) {
+	type S struct {
+		Labels map[string]string `mqp:"labels,nested=bracket"`
+		Flags  map[int]bool      `mqp:"flags,nested=dot"`
+		Status map[textStatus]int
+		Multi  map[string][]string `mqp:"multi,nested=dot"`
+		Points map[string]textPoint
+	}
+
+	query := map[string][]string{
+		"labels[env]":    {"prod"},
+		"labels[team]":   {"core"},
+		"labels[]":       {"ignored"},
+		"flags.1":        {"true"},
+		"flags.2":        {"false"},
+		"Status[active]": {"3"},
+		"multi.k":        {"a", "b"},
+		"Points[a]":      {"1:2"},
+	}
+
+	var v S
+	err := mapqueryparam.NewDecoder(mapqueryparam.WithNesting(mapqueryparam.NestingBracket)).Decode(query, &v)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	want := S{
+		Labels: map[string]string{"env": "prod", "team": "core"},
+		Flags:  map[int]bool{1: true, 2: false},
+		Status: map[textStatus]int{1: 3},
+		Multi:  map[string][]string{"k": {"a", "b"}},
+		Points: map[string]textPoint{"a": {X: 1, Y: 2}},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v, want)
+	}
+
+	tests := []struct {
+		name  string
+		query map[string][]string
+		field string
+	}{
+		{"InvalidKey", map[string][]string{"flags.x": {"true"}}, "flags.x"},
+		{"InvalidValue", map[string][]string{"flags.1": {"x"}}, "flags.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v S
+			err := mapqueryparam.Decode(tt.query, &v)
+			var decodeErr mapqueryparam.DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.Field() != tt.field {
+				t.Errorf("Decode() error = %v, want error for field %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestDecode_SliceStyle(t *testing.T) {
+	type S struct {
+		IDs    []int     `mqp:"ids"`
+		Names  []string  `mqp:"names,slice=pipe"`
+		Words  [2]string `mqp:"words,slice=space"`
+		Repeat []string  `mqp:"repeat,slice=repeat"`
+		Empty  []int     `mqp:"empty"`
+	}
+
+	query := map[string][]string{
+		"ids":    {"1,2", "3"},
+		"names":  {"a%7cb|100%25|%41"},
+		"words":  {"hello%20world x y"},
+		"repeat": {"a,b", "c"},
+		"empty":  {""},
+	}
+
+	var v S
+	err := mapqueryparam.NewDecoder(mapqueryparam.WithSliceStyle(mapqueryparam.SliceComma)).Decode(query, &v)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	want := S{
+		IDs:    []int{1, 2, 3},
+		Names:  []string{"a|b", "100%", "%41"},
+		Words:  [2]string{"hello world", "x"},
+		Repeat: []string{"a,b", "c"},
+		Empty:  []int{},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v, want)
+	}
+}
+
+func TestSliceStyle_RoundTrip(t *testing.T) {
+	type S struct {
+		Values []string `mqp:"v"`
+	}
+
+	for _, style := range []mapqueryparam.SliceStyle{
+		mapqueryparam.SliceRepeat, mapqueryparam.SliceComma, mapqueryparam.SliceSpace, mapqueryparam.SlicePipe,
+	} {
+		want := S{Values: []string{"a,b", "c d", "e|f", "%2C", "%"}}
+
+		query, err := mapqueryparam.NewEncoder(mapqueryparam.WithSliceStyle(style)).Encode(want)
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+
+		var got S
+		if err := mapqueryparam.NewDecoder(mapqueryparam.WithSliceStyle(style)).Decode(query, &got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("style %d: got = %q, want %q", style, got, want)
+		}
+	}
+}
+
+func TestDecode_IndexedSlices(t *testing.T) {
+	type Item struct {
+		Name string `mqp:"name"`
+	}
+	type S struct {
+		Values  []string  `mqp:"values"`
+		Array   [3]string `mqp:"array"`
+		Mixed   []int     `mqp:"mixed"`
+		Items   []Item    `mqp:"items"`
+		Pointer *[]int    `mqp:"pointer"`
+	}
+
+	query := map[string][]string{
+		"values[2]":       {"c"},
+		"values[0]":       {"a"},
+		"array[1]":        {"b"},
+		"array[5]":        {"f"},
+		"mixed[1]":        {"1"},
+		"mixed":           {"2", "3"},
+		"items[1].name":   {"y"},
+		"items[0].name":   {"x"},
+		"pointer[0]":      {"1"},
+		"values[x]":       {"ignored"},
+		"values[-1]":      {"ignored"},
+		"unrelated[1000]": {"ignored"},
+	}
+
+	var v S
+	if err := mapqueryparam.NewDecoder(mapqueryparam.WithNesting(mapqueryparam.NestingDot)).Decode(query, &v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	want := S{
+		Values:  []string{"a", "", "c"},
+		Array:   [3]string{"", "b", ""},
+		Mixed:   []int{2, 1, 3},
+		Items:   []Item{{Name: "x"}, {Name: "y"}},
+		Pointer: &[]int{1},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Decode() got = %+v, want %+v", v, want)
+	}
+
+	// indexed keys are ignored using the default styles
+	var plain S
+	if err := mapqueryparam.Decode(query, &plain); err != nil || !reflect.DeepEqual(plain, S{Mixed: []int{2, 3}}) {
+		t.Errorf("Decode() got = %+v, error = %v, want indexed keys ignored", plain, err)
+	}
+
+	dec := mapqueryparam.NewDecoder(mapqueryparam.WithMaxIndex(10),
+		mapqueryparam.WithSliceStyle(mapqueryparam.SliceIndexed))
+	if err := dec.Decode(map[string][]string{"values[10]": {"a"}}, &v); err != nil {
+		t.Errorf("Decode() error = %v", err)
+	}
+	for _, key := range []string{"values[11]", "values[99999999999999999999999]"} {
+		err := dec.Decode(map[string][]string{key: {"a"}}, &v)
+		var decodeErr mapqueryparam.DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Field() != key {
+			t.Errorf("Decode() error = %v, want error for field %s", err, key)
+		}
+	}
+}
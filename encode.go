@@ -1,7 +1,7 @@
 package mapqueryparam
 
 import (
-	"encoding/json"
+	"encoding"
 	"errors"
 	"fmt"
 	"net/url"
@@ -11,15 +11,58 @@ import (
 	"time"
 )
 
+// QueryParamMarshaler is implemented by types that encode themselves as the full set of values of a query parameter.
+type QueryParamMarshaler interface {
+	MarshalQueryParam() ([]string, error)
+}
+
+// Encoder encodes structs as query parameters. An Encoder is configured once using options and is safe for concurrent
+// use.
+type Encoder struct {
+	config config
+}
+
+// NewEncoder returns an Encoder configured with the given options.
+func NewEncoder(opts ...Option) *Encoder {
+	return &Encoder{config: newConfig(opts)}
+}
+
+// defaultEncoder is used by the package level encoding functions.
+var defaultEncoder = NewEncoder()
+
 // EncodeValues takes a input struct and encodes the content into the form of a set of query parameters.
 // Input must be a pointer to a struct. Same as Encode.
 func EncodeValues(v interface{}) (url.Values, error) {
-	return Encode(v)
+	return defaultEncoder.EncodeValues(v)
 }
 
 // Encode takes a input struct and encodes the content into the form of a set of query parameters.
 // Input must be a pointer to a struct. Same as EncodeValues.
 func Encode(v interface{}) (map[string][]string, error) {
+	return defaultEncoder.Encode(v)
+}
+
+// EncodeValues takes a input struct and encodes the content into the form of a set of query parameters.
+// Input must be a pointer to a struct. Same as Encode.
+func (e *Encoder) EncodeValues(v interface{}) (url.Values, error) {
+	return e.Encode(v)
+}
+
+// Encode takes a input struct and encodes the content into the form of a set of query parameters.
+// Input must be a pointer to a struct. Same as EncodeValues.
+func (e *Encoder) Encode(v interface{}) (res map[string][]string, err error) {
+	// panics, e.g. of marshaling methods, are returned as errors
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("%w: panic while encoding: %v", ErrInternal, r)
+		}
+	}()
+
+	return e.encode(v)
+}
+
+// encode encodes the input struct as query parameters.
+func (e *Encoder) encode(v interface{}) (map[string][]string, error) {
 	if v == nil {
 		return map[string][]string{}, nil
 	}
@@ -37,112 +80,471 @@ func Encode(v interface{}) (map[string][]string, error) {
 	if val.Kind() != reflect.Struct {
 		return nil, errors.New("unable to encode non-struct")
 	}
-	err := encodeFields(val, res)
+
+	p, err := e.config.structPlan(val.Type(), e.config.fieldOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	st := &encodeState{result: res}
+	err = e.config.encodeFields(st, "", val, p)
 	if err != nil {
 		return res, err
 	}
 
+	if p.remain != nil {
+		encodeRemain(st, fieldByIndex(val, p.remain.index))
+	}
+
 	return res, nil
 }
 
+// encodeRemain merges the parameters of the remain field into the result. Parameters already encoded by other fields
+// aren't overwritten.
+func encodeRemain(st *encodeState, v reflect.Value) {
+	if !v.IsValid() || v.IsNil() {
+		return
+	}
+	for key, s := range v.Convert(valuesType).Interface().(map[string][]string) {
+		if _, ok := st.result[key]; ok || len(s) == 0 {
+			continue
+		}
+		st.result[key] = append([]string(nil), s...)
+	}
+}
+
+// encodeState holds the state of a single call to Encode.
+type encodeState struct {
+	result map[string][]string
+
+	// depth is the number of structs the current field is nested in.
+	depth int
+	// visiting holds the pointers followed to reach the current field.
+	visiting map[visit]bool
+}
+
+// visit identifies a pointer, map or slice followed when encoding. Slices are identified by their length as well, as
+// slices of different lengths may share the same array.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// follow tracks the pointer, map or slice as being encoded under the given key, and returns its visit. Pointers being
+// encoded are tracked, as meeting them again means the value refers to itself, in which case an error is returned.
+// Arrays and empty or nil values are not tracked.
+func (st *encodeState) follow(key string, v reflect.Value) (visit, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() || (v.Kind() != reflect.Ptr && v.Len() == 0) {
+			return visit{}, nil
+		}
+	default:
+		return visit{}, nil
+	}
+
+	ptr := visit{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		ptr.len = v.Len()
+	}
+	if st.visiting[ptr] {
+		return ptr, fmt.Errorf("%w via field '%s'", ErrCycle, key)
+	}
+	if st.visiting == nil {
+		st.visiting = make(map[visit]bool)
+	}
+	st.visiting[ptr] = true
+	return ptr, nil
+}
+
+// unfollow ends the tracking of a visit returned by follow.
+func (st *encodeState) unfollow(ptr visit) {
+	if ptr.typ != nil {
+		delete(st.visiting, ptr)
+	}
+}
+
 // encodeFields iterates over the fields of the value passed to it, and stores the encoded fields in the results map.
-func encodeFields(val reflect.Value, result map[string][]string) error {
-	for i := 0; i < val.NumField(); i++ {
-		fTyp := val.Type().Field(i)
+// The keys of the fields are joined to the given key, when the value is a nested struct.
+func (c *config) encodeFields(st *encodeState, key string, val reflect.Value, p *structPlan) error {
+	for _, f := range p.fields {
+		// don't encode fields excluded from encoding
+		if f.encode == nil {
+			continue
+		}
 
-		// don't encode unexported fields
-		isUnexported := fTyp.PkgPath != ""
-		if isUnexported {
+		// don't encode fields of nil embedded structs
+		fVal := fieldByIndex(val, f.index)
+		if !fVal.IsValid() {
 			continue
 		}
 
 		// don't attempt to encode empty fields
-		fVal := val.Field(i)
-		if isEmptyValue(fVal) {
+		if isOmitted(fVal, f.omitEmpty) {
 			continue
 		}
 
-		// iterate over embedded fields
-		if fTyp.Anonymous {
-			for fVal.Kind() == reflect.Ptr {
-				if fVal.IsNil() {
-					continue
+		err := f.encode(st, joinKey(key, f.names[0], p.opts.nesting), fVal)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldEncoder encodes a field of the input struct, storing the parameters under the given key.
+type fieldEncoder func(st *encodeState, key string, v reflect.Value) error
+
+// valuesEncoder encodes a field of the input struct as a set of parameter strings.
+type valuesEncoder func(v reflect.Value) ([]string, error)
+
+// valueEncoder encodes a single value as a parameter string.
+type valueEncoder func(v reflect.Value) (string, error)
+
+// fieldEncoder returns the encoder for a field of the given type. Values nested using the given style, as well as
+// arrays and slices using SliceIndexed, are spread over several parameters. Other fields are stored as a set of
+// parameter strings under the key of the field.
+func (c *config) fieldEncoder(t reflect.Type, opts fieldOptions) fieldEncoder {
+	if c.isNested(t, opts) || (c.isSequence(t) && opts.slices == SliceIndexed) {
+		switch t.Kind() {
+		case reflect.Struct:
+			return func(st *encodeState, key string, v reflect.Value) error {
+				if st.depth >= c.maxDepth {
+					return fmt.Errorf("%w: depth of field '%s' exceeds maximum of %d", ErrMaxDepth, key, c.maxDepth)
+				}
+
+				p, err := c.structPlan(t, opts)
+				if err != nil {
+					return err
 				}
-				fVal = fVal.Elem()
+
+				st.depth++
+				err = c.encodeFields(st, key, v, p)
+				st.depth--
+				return err
 			}
-			err := encodeFields(fVal, result)
+		case reflect.Map:
+			return c.mapEncoder(t, opts)
+		default:
+			return c.sequenceEncoder(t, opts)
+		}
+	}
+
+	if t.Kind() == reflect.Ptr && (c.isNested(indirectType(t), opts) ||
+		(c.isSequence(indirectType(t)) && opts.slices == SliceIndexed)) {
+		enc := c.fieldEncoder(t.Elem(), opts)
+		return func(st *encodeState, key string, v reflect.Value) error {
+			if v.IsNil() {
+				return nil
+			}
+
+			ptr, err := st.follow(key, v)
 			if err != nil {
 				return err
 			}
-			continue
+			err = enc(st, key, v.Elem())
+			st.unfollow(ptr)
+			return err
 		}
+	}
 
-		d, err := encodeField(fVal)
+	enc := c.valuesEncoder(t, opts)
+	mayCycle := c.mayCycle(t, make(map[reflect.Type]bool))
+	return func(st *encodeState, key string, v reflect.Value) error {
+		if mayCycle {
+			if err := c.checkCycle(st, key, v, opts); err != nil {
+				return err
+			}
+		}
+
+		d, err := enc(v)
 		if err != nil {
 			return err
 		}
 		if len(d) == 0 {
-			continue
+			return nil
 		}
 
-		fieldTags := getFieldTags(fTyp)
+		st.result[key] = d
+		return nil
+	}
+}
+
+// mayCycle reports whether values of the given type may refer to themselves, which requires the type to contain an
+// interface, or to refer to itself through pointers, maps or slices. Types handled by a converter or a marshaling
+// interface aren't inspected, as they're encoded by their own methods. The types being inspected are held by visiting.
+func (c *config) mayCycle(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[t] {
+		return true
+	}
+	if c.isCustom(t) {
+		return false
+	}
+
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Array, reflect.Slice:
+		return c.mayCycle(t.Elem(), visiting)
+	case reflect.Map:
+		return c.mayCycle(t.Key(), visiting) || c.mayCycle(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" && c.mayCycle(f.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-		result[fieldTags[0]] = d
+// checkCycle returns an error if the value refers to itself, as it would make the value encoders or the marshal
+// function recurse endlessly. The error names the path at which the cycle was met.
+func (c *config) checkCycle(st *encodeState, key string, v reflect.Value, opts fieldOptions) error {
+	if st.visiting == nil {
+		st.visiting = make(map[visit]bool)
+	}
+	if path, ok := c.findCycle(st, key, v, opts); ok {
+		return fmt.Errorf("%w via field '%s'", ErrCycle, path)
 	}
 	return nil
 }
 
-// getFieldTags returns the tags or names that a struct field is identified by. It prioritizes the MQP tag over the
-// json tag. It defaults to the field name if neither tag is available.
-func getFieldTags(t reflect.StructField) (res []string) {
-	if tags := t.Tag.Get(mapQueryParameterTagName); len(tags) > 0 {
-		for _, s := range strings.Split(tags, ",") {
-			if len(s) > 0 {
-				res = append(res, s)
-			}
+// findCycle follows the pointers, maps and slices of the value, and returns the path of the first one met again while
+// it's being followed, if any. The path is made of the given path, followed by the encoded names of struct fields and
+// the indexes and keys of elements, the same way as the fields met by the encoders. Values handled by a converter or a
+// marshaling interface aren't followed.
+func (c *config) findCycle(st *encodeState, path string, v reflect.Value, opts fieldOptions) (string, bool) {
+	if c.isCustom(v.Type()) {
+		return "", false
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() || (v.Kind() != reflect.Ptr && v.Len() == 0) {
+			return "", false
+		}
+		ptr := visit{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			ptr.len = v.Len()
+		}
+		if st.visiting[ptr] {
+			return path, true
 		}
+		st.visiting[ptr] = true
+		defer delete(st.visiting, ptr)
 	}
 
-	// ignore json tags and field name if mqp tag is present
-	if len(res) > 0 {
-		return
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			return c.findCycle(st, path, v.Elem(), opts)
+		}
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if p, ok := c.findCycle(st, fmt.Sprintf("%s[%d]", path, i), v.Index(i), opts); ok {
+				return p, true
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if p, ok := c.findCycle(st, fmt.Sprintf("%s[%v]", path, iter.Key()), iter.Value(), opts); ok {
+				return p, true
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		if sp, err := c.structPlan(t, opts); err == nil {
+			for _, f := range sp.fields {
+				fVal := fieldByIndex(v, f.index)
+				if !fVal.IsValid() {
+					continue
+				}
+				name := f.name
+				if len(f.names) > 0 {
+					name = f.names[0]
+				}
+				if p, ok := c.findCycle(st, path+"."+name, fVal, opts); ok {
+					return p, true
+				}
+			}
+			return "", false
+		}
+
+		// structs which cannot be planned are followed using the names of their fields
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" {
+				if p, ok := c.findCycle(st, path+"."+f.Name, v.Field(i), opts); ok {
+					return p, true
+				}
+			}
+		}
 	}
+	return "", false
+}
 
-	if tags := t.Tag.Get("json"); len(tags) > 0 {
-		jsonTags := strings.Split(tags, ",")
-		if len(jsonTags) > 0 && len(jsonTags[0]) > 0 {
-			res = append(res, jsonTags[0])
+// mapEncoder returns the encoder for a map nested using the given style. Each entry is encoded under the key of the
+// map joined with the key of the entry, e.g. `labels[env]` or `labels.env`. Keys and values are encoded according to
+// their types.
+func (c *config) mapEncoder(t reflect.Type, opts fieldOptions) fieldEncoder {
+	keyEnc := c.valueEncoder(t.Key(), opts)
+	enc := c.lazyFieldEncoder(t.Elem(), opts)
+	return func(st *encodeState, key string, v reflect.Value) error {
+		ptr, err := st.follow(key, v)
+		if err != nil {
+			return err
+		}
+		defer st.unfollow(ptr)
+
+		iter := v.MapRange()
+		for iter.Next() {
+			k, err := keyEnc(iter.Key())
+			if err != nil {
+				return err
+			}
+			if seps := keySeparators(opts.nesting); strings.ContainsAny(k, seps) {
+				return fmt.Errorf("key '%s' of map field '%s' contains a nesting separator of '%s'", k, key, seps)
+			}
+
+			err = enc(st, joinKey(key, k, opts.nesting), iter.Value())
+			if err != nil {
+				return err
+			}
 		}
+		return nil
 	}
+}
 
-	// ignore field name if json tag is present
-	if len(res) > 0 {
-		return
+// sequenceEncoder returns the encoder for an array or slice nested using the given style, or using SliceIndexed.
+// Elements are encoded under indexed keys, e.g. `users[0][name]`, when using SliceIndexed or when they're nested
+// themselves. Other elements are stored in order under the append style key, e.g. `tags[]`.
+func (c *config) sequenceEncoder(t reflect.Type, opts fieldOptions) fieldEncoder {
+	if opts.slices == SliceIndexed || c.isNested(indirectType(t.Elem()), opts) {
+		enc := c.lazyFieldEncoder(t.Elem(), opts)
+		return func(st *encodeState, key string, v reflect.Value) error {
+			ptr, err := st.follow(key, v)
+			if err != nil {
+				return err
+			}
+			defer st.unfollow(ptr)
+
+			for i := 0; i < v.Len(); i++ {
+				err := enc(st, indexKey(key, strconv.Itoa(i)), v.Index(i))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
 	}
 
-	res = append(res, t.Name)
+	enc := c.valuesEncoder(t, opts)
+	mayCycle := c.mayCycle(t, make(map[reflect.Type]bool))
+	return func(st *encodeState, key string, v reflect.Value) error {
+		if mayCycle {
+			if err := c.checkCycle(st, key, v, opts); err != nil {
+				return err
+			}
+		}
 
-	return
+		d, err := enc(v)
+		if err != nil {
+			return err
+		}
+		if len(d) == 0 {
+			return nil
+		}
+
+		st.result[key+"[]"] = d
+		return nil
+	}
 }
 
-// encodeField encodes a field of the input struct as a set of parameter strings. Arrays and slices are represented as
-// multiple strings. Other values are encoded as a single string
-func encodeField(v reflect.Value) ([]string, error) {
-	switch v.Kind() {
+// valuesEncoder returns the encoder for a field of the given type. Types with a registered converter are encoded as a
+// single value using it. Types implementing QueryParamMarshaler are encoded using it. Arrays and slices are represented
+// as multiple strings, or a single delimited string depending on the slice style, unless they implement
+// encoding.TextMarshaler. Other values are encoded as a single string.
+func (c *config) valuesEncoder(t reflect.Type, opts fieldOptions) valuesEncoder {
+	if conv, ok := c.converter(t, opts); ok && conv.encode != nil {
+		return singleValuesEncoder(conv.valueEncoder())
+	}
+
+	if implementsMarshaler(t, marshalerType) {
+		return func(v reflect.Value) ([]string, error) {
+			return marshalerValue(v, marshalerType).Interface().(QueryParamMarshaler).MarshalQueryParam()
+		}
+	}
+
+	if implementsMarshaler(t, textMarshalerType) {
+		return singleValuesEncoder(c.valueEncoder(t, opts))
+	}
+
+	switch t.Kind() {
 	case reflect.Array, reflect.Slice:
-		res := make([]string, v.Len())
-		for i := 0; i < v.Len(); i++ {
-			s, err := encodeValue(v.Index(i))
-			if err != nil {
-				return nil, err
+		enc := c.valueEncoder(t.Elem(), opts)
+		delim := opts.slices.delimiter()
+		return func(v reflect.Value) ([]string, error) {
+			res := make([]string, v.Len())
+			for i := 0; i < v.Len(); i++ {
+				s, err := enc(v.Index(i))
+				if err != nil {
+					return nil, err
+				}
+				res[i] = s
+			}
+			if delim != "" && len(res) > 0 {
+				return []string{joinDelimited(res, delim)}, nil
 			}
-			res[i] = s
+			return res, nil
+		}
+	case reflect.Ptr:
+		enc := c.valuesEncoder(t.Elem(), opts)
+		return func(v reflect.Value) ([]string, error) {
+			if v.IsNil() {
+				return nil, nil
+			}
+			return enc(v.Elem())
+		}
+	case reflect.Interface:
+		return func(v reflect.Value) ([]string, error) {
+			if v.IsNil() {
+				return nil, nil
+			}
+			return c.valuesEncoder(v.Elem().Type(), opts)(v.Elem())
 		}
-		return res, nil
-	case reflect.Interface, reflect.Ptr:
-		return encodeField(v.Elem())
 	default:
-		s, err := encodeValue(v)
+		return singleValuesEncoder(c.valueEncoder(t, opts))
+	}
+}
+
+// joinDelimited joins the values using the delimiter. Occurrences of the delimiter and the percent sign within the
+// values are percent-encoded, so they can be told apart when splitting.
+func joinDelimited(s []string, delim string) string {
+	escaper := strings.NewReplacer("%", "%25", delim, percentEncode(delim))
+	for i := range s {
+		s[i] = escaper.Replace(s[i])
+	}
+	return strings.Join(s, delim)
+}
+
+// percentEncode returns the percent-encoding of every byte of the string.
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		fmt.Fprintf(&b, "%%%02X", s[i])
+	}
+	return b.String()
+}
+
+// singleValuesEncoder returns a values encoder representing the field as a single string.
+func singleValuesEncoder(enc valueEncoder) valuesEncoder {
+	return func(v reflect.Value) ([]string, error) {
+		s, err := enc(v)
 		if err != nil {
 			return nil, err
 		}
@@ -150,42 +552,102 @@ func encodeField(v reflect.Value) ([]string, error) {
 	}
 }
 
-// encodeValue encodes a single value as a string. Base types are formatted using `strconv`. Maps and structs are
-// encoded as json objects using standard json marshaling. Channels and functions are skipped, as they're not supported.
-func encodeValue(v reflect.Value) (string, error) {
-	switch v.Kind() {
+// valueEncoder returns the encoder for a single value of the given type. Types with a registered converter are encoded
+// using it, followed by types implementing encoding.TextMarshaler. Base types are formatted using `strconv`. Maps and
+// structs are encoded using the configured marshal function, json by default. Channels and functions are skipped, as
+// they're not supported.
+func (c *config) valueEncoder(t reflect.Type, opts fieldOptions) valueEncoder {
+	if conv, ok := c.converter(t, opts); ok && conv.encode != nil {
+		return conv.valueEncoder()
+	}
+
+	if implementsMarshaler(t, textMarshalerType) {
+		return func(v reflect.Value) (string, error) {
+			b, err := marshalerValue(v, textMarshalerType).Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return "", err
+			}
+			return string(b), nil
+		}
+	}
+
+	switch t.Kind() {
 	case reflect.String:
-		return v.String(), nil
+		return func(v reflect.Value) (string, error) {
+			return v.String(), nil
+		}
 	case reflect.Bool:
-		return strconv.FormatBool(v.Bool()), nil
+		return func(v reflect.Value) (string, error) {
+			return strconv.FormatBool(v.Bool()), nil
+		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return strconv.FormatInt(v.Int(), 10), nil
+		return func(v reflect.Value) (string, error) {
+			return strconv.FormatInt(v.Int(), 10), nil
+		}
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		return strconv.FormatUint(v.Uint(), 10), nil
+		return func(v reflect.Value) (string, error) {
+			return strconv.FormatUint(v.Uint(), 10), nil
+		}
 	case reflect.Float32:
-		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+		return func(v reflect.Value) (string, error) {
+			return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+		}
 	case reflect.Float64:
-		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+		return func(v reflect.Value) (string, error) {
+			return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+		}
 	case reflect.Complex64:
-		return strconv.FormatComplex(v.Complex(), 'f', -1, 64), nil
+		return func(v reflect.Value) (string, error) {
+			return strconv.FormatComplex(v.Complex(), 'f', -1, 64), nil
+		}
 	case reflect.Complex128:
-		return strconv.FormatComplex(v.Complex(), 'f', -1, 128), nil
+		return func(v reflect.Value) (string, error) {
+			return strconv.FormatComplex(v.Complex(), 'f', -1, 128), nil
+		}
 	case reflect.Map, reflect.Struct:
-		i := v.Interface()
-		switch t := i.(type) {
-		case time.Time:
-			return t.Format(time.RFC3339Nano), nil
-		default:
-			b, err := json.Marshal(i)
+		return func(v reflect.Value) (string, error) {
+			b, err := c.marshal(v.Interface())
 			return string(b), err
 		}
-	case reflect.Interface, reflect.Ptr:
-		return encodeValue(v.Elem())
+	case reflect.Ptr:
+		enc := c.valueEncoder(t.Elem(), opts)
+		return func(v reflect.Value) (string, error) {
+			if v.IsNil() {
+				return "", nil
+			}
+			return enc(v.Elem())
+		}
+	case reflect.Interface:
+		return func(v reflect.Value) (string, error) {
+			if v.IsNil() {
+				return "", nil
+			}
+			return c.valueEncoder(v.Elem().Type(), opts)(v.Elem())
+		}
 	case reflect.Chan, reflect.Func:
-		return "", nil
+		return func(v reflect.Value) (string, error) {
+			return "", nil
+		}
 	default:
-		return "", fmt.Errorf("unsupported field kind: %s", v.Kind().String())
+		return func(v reflect.Value) (string, error) {
+			return "", fmt.Errorf("unsupported field kind: %s", t.Kind().String())
+		}
+	}
+}
+
+// isOmitted reports whether a field value should be left out of the encoded result. Empty values are omitted if
+// omitEmpty is set, while nil values and unsupported kinds are always omitted.
+func isOmitted(v reflect.Value, omitEmpty bool) bool {
+	if omitEmpty {
+		return isEmptyValue(v)
 	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	case reflect.Chan, reflect.Func:
+		return true
+	}
+	return false
 }
 
 // isEmptyValue validated whether a value is empty/zero/nil. Used to determine if a field should be omitted from the
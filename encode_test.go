@@ -1,13 +1,94 @@
 package mapqueryparam_test
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/h-celel/mapqueryparam"
 )
 
+// textStatus implements encoding.TextMarshaler and encoding.TextUnmarshaler as an enum.
+type textStatus int
+
+func (s textStatus) MarshalText() ([]byte, error) {
+	switch s {
+	case 1:
+		return []byte("active"), nil
+	case 2:
+		return []byte("inactive"), nil
+	}
+	return nil, errors.New("unknown status")
+}
+
+func (s *textStatus) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "active":
+		*s = 1
+	case "inactive":
+		*s = 2
+	default:
+		return errors.New("unknown status")
+	}
+	return nil
+}
+
+// textPoint implements encoding.TextMarshaler and encoding.TextUnmarshaler on the pointer receiver.
+type textPoint struct {
+	X, Y int
+}
+
+func (p *textPoint) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d:%d", p.X, p.Y)), nil
+}
+
+func (p *textPoint) UnmarshalText(b []byte) error {
+	_, err := fmt.Sscanf(string(b), "%d:%d", &p.X, &p.Y)
+	return err
+}
+
+// boundingBox implements QueryParamMarshaler and QueryParamUnmarshaler, spreading its corners over four values.
+type boundingBox struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+func (b boundingBox) MarshalQueryParam() ([]string, error) {
+	res := make([]string, 4)
+	for i, f := range []float64{b.MinX, b.MinY, b.MaxX, b.MaxY} {
+		res[i] = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return res, nil
+}
+
+func (b *boundingBox) UnmarshalQueryParam(s []string) error {
+	if len(s) != 4 {
+		return errors.New("bounding box requires four values")
+	}
+	for i, f := range []*float64{&b.MinX, &b.MinY, &b.MaxX, &b.MaxY} {
+		var err error
+		if *f, err = strconv.ParseFloat(s[i], 64); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// panicText panics when marshaled or unmarshaled.
+type panicText struct{}
+
+func (panicText) MarshalText() ([]byte, error) {
+	panic("marshal")
+}
+
+func (*panicText) UnmarshalText([]byte) error {
+	panic("unmarshal")
+}
+
 func TestEncode(t *testing.T) {
 	type EmbeddedStruct struct {
 		A string
@@ -15,6 +96,7 @@ func TestEncode(t *testing.T) {
 	type EmbeddedStruct2 struct {
 		EmbeddedStruct
 	}
+	type EmbeddedString string
 
 	type args struct {
 		v interface{}
@@ -62,6 +144,15 @@ func TestEncode(t *testing.T) {
 		{"SubEmbeddedStruct", args{struct{ EmbeddedStruct2 }{EmbeddedStruct2{EmbeddedStruct{A: "a"}}}}, map[string][]string{"A": {"a"}}, false},
 		{"EmbeddedPointer", args{struct{ *EmbeddedStruct }{&EmbeddedStruct{A: "a"}}}, map[string][]string{"A": {"a"}}, false},
 		{"EmbeddedNilPointer", args{struct{ *EmbeddedStruct }{EmbeddedStruct: nil}}, map[string][]string{}, false},
+		{"TextMarshaler", args{struct{ Value textStatus }{1}}, map[string][]string{"Value": {"active"}}, false},
+		{"TextMarshalerError", args{struct{ Value textStatus }{3}}, map[string][]string{}, true},
+		{"TextMarshalerPointerReceiver", args{struct{ Value textPoint }{textPoint{1, 2}}}, map[string][]string{"Value": {"1:2"}}, false},
+		{"TextMarshalerPointer", args{struct{ Value *textPoint }{&textPoint{1, 2}}}, map[string][]string{"Value": {"1:2"}}, false},
+		{"TextMarshalerSlice", args{struct{ Value []textStatus }{[]textStatus{1, 2}}}, map[string][]string{"Value": {"active", "inactive"}}, false},
+		{"TextMarshalerSliceType", args{struct{ Value net.IP }{net.IPv4(10, 0, 0, 1)}}, map[string][]string{"Value": {"10.0.0.1"}}, false},
+		{"QueryParamMarshaler", args{struct{ Value boundingBox }{boundingBox{1, 2, 3, 4}}}, map[string][]string{"Value": {"1", "2", "3", "4"}}, false},
+		{"QueryParamMarshalerPointer", args{struct{ Value *boundingBox }{&boundingBox{1, 2, 3, 4}}}, map[string][]string{"Value": {"1", "2", "3", "4"}}, false},
+		{"EmbeddedNonStruct", args{struct{ EmbeddedString }{"a"}}, map[string][]string{"EmbeddedString": {"a"}}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -76,3 +167,514 @@ func TestEncode(t *testing.T) {
 		})
 	}
 }
+
+func TestEncoder(t *testing.T) {
+	type S struct {
+		A string `q:"a"`
+		B int
+		C time.Time
+		D struct{ E string }
+	}
+
+	enc := mapqueryparam.NewEncoder(
+		mapqueryparam.WithTagName("q"),
+		mapqueryparam.WithTimeLayout("2006-01-02"),
+		mapqueryparam.WithOmitEmpty(false),
+		mapqueryparam.WithMarshalFunc(func(v interface{}) ([]byte, error) {
+			return []byte("marshaled"), nil
+		}),
+	)
+
+	got, err := enc.Encode(S{A: "foo", C: time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	want := map[string][]string{"a": {"foo"}, "B": {"0"}, "C": {"2021-03-04"}, "D": {"marshaled"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+}
+
+func TestEncode_KeepZero(t *testing.T) {
+	type S struct {
+		Page     int      `mqp:"page,keepzero"`
+		Enabled  bool     `mqp:"enabled,keepzero"`
+		Discount *float64 `mqp:"discount,keepzero"`
+		Limit    *int     `mqp:"limit"`
+		Name     string   `mqp:"name,omitempty"`
+		Sort     string   `mqp:"sort"`
+	}
+
+	zero := 0
+	tests := []struct {
+		name string
+		opts []mapqueryparam.Option
+		v    S
+		want map[string][]string
+	}{
+		{
+			name: "OmitEmpty",
+			v:    S{Limit: &zero},
+			want: map[string][]string{"page": {"0"}, "enabled": {"false"}, "limit": {"0"}},
+		},
+		{
+			name: "KeepEmpty",
+			opts: []mapqueryparam.Option{mapqueryparam.WithOmitEmpty(false)},
+			v:    S{},
+			want: map[string][]string{"page": {"0"}, "enabled": {"false"}, "sort": {""}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapqueryparam.NewEncoder(tt.opts...).Encode(tt.v)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encode() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	type Invalid struct {
+		A int `mqp:"a,keepzero,omitempty"`
+	}
+	if _, err := mapqueryparam.Encode(Invalid{}); err == nil {
+		t.Errorf("Encode() expected error for conflicting options")
+	}
+}
+
+func TestEncode_Skip(t *testing.T) {
+	type Embedded struct {
+		E string `mqp:"e"`
+	}
+	type S struct {
+		Embedded   `mqp:"-"`
+		A          string `mqp:"-"`
+		B          string `json:"-"`
+		C          string `json:"-,"`
+		D          string `mqp:"-,"`
+		EncodeOnly string `mqp:"encode,encodeonly"`
+		DecodeOnly string `mqp:"decode,decodeonly"`
+	}
+
+	v := S{Embedded{"e"}, "a", "b", "c", "d", "x", "y"}
+	got, err := mapqueryparam.Encode(v)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{"-": {"d"}, "encode": {"x"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+}
+
+func TestEncode_NilEmbedded(t *testing.T) {
+	type Page struct {
+		Page  int `mqp:"page,keepzero"`
+		Limit int `mqp:"limit"`
+	}
+	type S struct {
+		*Page
+		Name string `mqp:"name"`
+	}
+
+	for _, enc := range []*mapqueryparam.Encoder{
+		mapqueryparam.NewEncoder(),
+		mapqueryparam.NewEncoder(mapqueryparam.WithOmitEmpty(false)),
+	} {
+		got, err := enc.Encode(S{Name: "x"})
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		if want := map[string][]string{"name": {"x"}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("Encode() got = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestEncode_Remain(t *testing.T) {
+	type S struct {
+		Name  string              `mqp:"name"`
+		Extra map[string][]string `mqp:",remain"`
+	}
+
+	v := S{Name: "x", Extra: map[string][]string{"name": {"y"}, "utm_source": {"a"}, "empty": {}}}
+	got, err := mapqueryparam.Encode(v)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{"name": {"x"}, "utm_source": {"a"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+}
+
+func TestEncode_Cycle(t *testing.T) {
+	type Node struct {
+		Name string `mqp:"name"`
+		Next *Node  `mqp:"next"`
+	}
+
+	enc := mapqueryparam.NewEncoder(mapqueryparam.WithNesting(mapqueryparam.NestingDot))
+
+	shared := &Node{Name: "s"}
+	got, err := enc.Encode(struct {
+		A *Node `mqp:"a"`
+		B *Node `mqp:"b"`
+	}{shared, shared})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{"a.name": {"s"}, "b.name": {"s"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+
+	n := &Node{Name: "a", Next: &Node{Name: "b"}}
+	n.Next.Next = n
+	_, err = enc.Encode(struct {
+		Head *Node `mqp:"head"`
+	}{n})
+	if !errors.Is(err, mapqueryparam.ErrCycle) || !strings.Contains(err.Error(), "head.next.next") {
+		t.Errorf("Encode() error = %v, want cycle via field head.next.next", err)
+	}
+
+	// values encoded using the marshal function are checked before marshaling them
+	for _, enc := range []*mapqueryparam.Encoder{
+		mapqueryparam.NewEncoder(),
+		mapqueryparam.NewEncoder(mapqueryparam.WithMarshalFunc(func(v interface{}) ([]byte, error) {
+			return nil, errors.New("unexpected call")
+		})),
+	} {
+		_, err = enc.Encode(struct {
+			Head *Node `mqp:"head"`
+		}{n})
+		if !errors.Is(err, mapqueryparam.ErrCycle) || !strings.Contains(err.Error(), "head.next.next") {
+			t.Errorf("Encode() error = %v, want cycle via field head.next.next", err)
+		}
+	}
+
+	var self interface{}
+	self = &self
+	_, err = mapqueryparam.Encode(struct {
+		List []interface{} `mqp:"list"`
+	}{[]interface{}{"a", self}})
+	if !errors.Is(err, mapqueryparam.ErrCycle) || !strings.Contains(err.Error(), "list[1]") {
+		t.Errorf("Encode() error = %v, want cycle via field list[1]", err)
+	}
+
+	got, err = mapqueryparam.Encode(struct {
+		A *Node `mqp:"a"`
+		B *Node `mqp:"b"`
+	}{shared, shared})
+	if err != nil || len(got) != 2 {
+		t.Errorf("Encode() got = %v, error = %v, want shared pointers encoded", got, err)
+	}
+
+	var deep Node
+	cur := &deep
+	for i := 0; i < 3; i++ {
+		cur.Next = &Node{Name: strconv.Itoa(i)}
+		cur = cur.Next
+	}
+	_, err = mapqueryparam.NewEncoder(
+		mapqueryparam.WithNesting(mapqueryparam.NestingDot),
+		mapqueryparam.WithMaxDepth(2),
+	).Encode(deep)
+	if !errors.Is(err, mapqueryparam.ErrMaxDepth) {
+		t.Errorf("Encode() error = %v, want ErrMaxDepth", err)
+	}
+}
+
+func TestEncode_Recursive(t *testing.T) {
+	type List []List
+	type Map map[string]Map
+	type S struct {
+		List List `mqp:"list"`
+		Map  Map  `mqp:"map,nested=dot"`
+	}
+
+	got, err := mapqueryparam.Encode(S{Map: Map{"a": Map{"b": nil}}})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+
+	// slices of slices aren't supported, but compiling them mustn't recurse endlessly
+	if _, err = mapqueryparam.Encode(S{List: List{nil, List{nil}}}); err == nil {
+		t.Errorf("Encode() error = nil, want unsupported field kind")
+	}
+
+	m := Map{}
+	m["a"] = m
+	_, err = mapqueryparam.Encode(S{Map: m})
+	if !errors.Is(err, mapqueryparam.ErrCycle) || !strings.Contains(err.Error(), "map.a") {
+		t.Errorf("Encode() error = %v, want cycle via field map.a", err)
+	}
+
+	l := List{nil}
+	l[0] = l
+	_, err = mapqueryparam.Encode(S{List: l})
+	if !errors.Is(err, mapqueryparam.ErrCycle) {
+		t.Errorf("Encode() error = %v, want ErrCycle", err)
+	}
+
+	var v S
+	err = mapqueryparam.NewDecoder(mapqueryparam.WithNesting(mapqueryparam.NestingBracket)).Decode(
+		map[string][]string{"map.a.b": {"x"}, "list[0][1]": {"y"}}, &v)
+	if !errors.Is(err, mapqueryparam.ErrUnsupportedType) {
+		t.Errorf("Decode() error = %v, want ErrUnsupportedType", err)
+	}
+}
+
+func TestEncode_Panic(t *testing.T) {
+	type S struct {
+		Value panicText `mqp:"value"`
+	}
+
+	got, err := mapqueryparam.Encode(S{})
+	if !errors.Is(err, mapqueryparam.ErrInternal) || got != nil {
+		t.Errorf("Encode() got = %v, error = %v, want ErrInternal", got, err)
+	}
+}
+
+func TestEncode_Nesting(t *testing.T) {
+	type Inner struct {
+		Name string `json:"name"`
+		Age  int    `mqp:"age"`
+	}
+	type Middle struct {
+		Inner  Inner  `mqp:"inner"`
+		Ptr    *Inner `mqp:"ptr"`
+		Nil    *Inner `mqp:"nil"`
+		AsJSON Inner  `mqp:"json,nested=json"`
+	}
+	type Root struct {
+		Filter Middle `mqp:"filter"`
+		Other  Inner  `mqp:"other"`
+	}
+
+	v := Root{
+		Filter: Middle{
+			Inner:  Inner{Name: "x", Age: 3},
+			Ptr:    &Inner{Name: "y"},
+			AsJSON: Inner{Name: "z"},
+		},
+		Other: Inner{Name: "w"},
+	}
+
+	got, err := mapqueryparam.NewEncoder(mapqueryparam.WithNesting(mapqueryparam.NestingDot)).Encode(v)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{
+		"filter.inner.name": {"x"},
+		"filter.inner.age":  {"3"},
+		"filter.ptr.name":   {"y"},
+		"filter.json":       {`{"name":"z","Age":0}`},
+		"other.name":        {"w"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+
+	type Tagged struct {
+		Filter Middle `mqp:"filter,nested=dot"`
+		Other  Inner  `mqp:"other"`
+	}
+
+	got, err = mapqueryparam.Encode(Tagged{Filter: v.Filter, Other: v.Other})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want["other"] = []string{`{"name":"w","Age":0}`}
+	delete(want, "other.name")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+
+	type Invalid struct {
+		Filter Inner `mqp:"filter,nested=unknown"`
+	}
+	if _, err := mapqueryparam.Encode(Invalid{}); err == nil {
+		t.Errorf("Encode() expected error for unknown nesting style")
+	}
+}
+
+func TestEncode_Brackets(t *testing.T) {
+	type Address struct {
+		City string `mqp:"city"`
+	}
+	type User struct {
+		Name      string            `mqp:"name"`
+		Tags      []string          `mqp:"tags"`
+		Meta      map[string]string `mqp:"meta"`
+		Addresses []Address         `mqp:"addresses"`
+		Home      *Address          `mqp:"home"`
+	}
+	type Request struct {
+		User User     `mqp:"user"`
+		IDs  [2]int   `mqp:"ids"`
+		Sort []string `mqp:"sort,nested=json"`
+	}
+
+	v := Request{
+		User: User{
+			Name:      "x",
+			Tags:      []string{"a", "b"},
+			Meta:      map[string]string{"k": "v"},
+			Addresses: []Address{{City: "c1"}, {City: "c2"}},
+			Home:      &Address{City: "c3"},
+		},
+		IDs:  [2]int{1, 2},
+		Sort: []string{"name"},
+	}
+
+	got, err := mapqueryparam.NewEncoder(mapqueryparam.WithNesting(mapqueryparam.NestingBracket)).Encode(v)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{
+		"user[name]":               {"x"},
+		"user[tags][]":             {"a", "b"},
+		"user[meta][k]":            {"v"},
+		"user[addresses][0][city]": {"c1"},
+		"user[addresses][1][city]": {"c2"},
+		"user[home][city]":         {"c3"},
+		"ids[]":                    {"1", "2"},
+		"sort":                     {"name"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+}
+
+func TestEncode_Maps(t *testing.T) {
+	type S struct {
+		Labels map[string]string `mqp:"labels,nested=bracket"`
+		Flags  map[int]bool      `mqp:"flags,nested=dot"`
+		Status map[textStatus]int
+		Multi  map[string][]string `mqp:"multi,nested=dot"`
+		JSON   map[string]int      `mqp:"json"`
+	}
+
+	v := S{
+		Labels: map[string]string{"env": "prod", "team": "core"},
+		Flags:  map[int]bool{1: true, 2: false},
+		Status: map[textStatus]int{1: 3},
+		Multi:  map[string][]string{"k": {"a", "b"}},
+		JSON:   map[string]int{"a": 1},
+	}
+
+	got, err := mapqueryparam.NewEncoder(mapqueryparam.WithNesting(mapqueryparam.NestingBracket)).Encode(v)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{
+		"labels[env]":    {"prod"},
+		"labels[team]":   {"core"},
+		"flags.1":        {"true"},
+		"flags.2":        {"false"},
+		"Status[active]": {"3"},
+		"multi.k":        {"a", "b"},
+		"json[a]":        {"1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+
+	// keys containing the nesting separator couldn't be decoded
+	for _, tt := range []struct {
+		name    string
+		nesting mapqueryparam.NestingStyle
+		labels  map[string]string
+	}{
+		{"Dot", mapqueryparam.NestingDot, map[string]string{"a.b": "x", "c": "y"}},
+		{"DotBracket", mapqueryparam.NestingDot, map[string]string{"a[0]": "x"}},
+		{"Bracket", mapqueryparam.NestingBracket, map[string]string{"a]b": "x"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := mapqueryparam.NewEncoder(mapqueryparam.WithNesting(tt.nesting)).Encode(struct {
+				Labels map[string]string `mqp:"labels"`
+			}{tt.labels})
+			if err == nil || !strings.Contains(err.Error(), "separator") {
+				t.Errorf("Encode() error = %v, want nesting separator error", err)
+			}
+		})
+	}
+}
+
+func TestEncode_SliceStyle(t *testing.T) {
+	type S struct {
+		IDs    []int     `mqp:"ids"`
+		Names  []string  `mqp:"names,slice=pipe"`
+		Words  [2]string `mqp:"words,slice=space"`
+		Repeat []string  `mqp:"repeat,slice=repeat"`
+	}
+
+	v := S{
+		IDs:    []int{1, 2, 3},
+		Names:  []string{"a|b", "100%"},
+		Words:  [2]string{"hello world", "x"},
+		Repeat: []string{"a,b", "c"},
+	}
+
+	got, err := mapqueryparam.NewEncoder(mapqueryparam.WithSliceStyle(mapqueryparam.SliceComma)).Encode(v)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{
+		"ids":    {"1,2,3"},
+		"names":  {"a%7Cb|100%25"},
+		"words":  {"hello%20world x"},
+		"repeat": {"a,b", "c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+}
+
+func TestEncode_IndexedSlices(t *testing.T) {
+	type Item struct {
+		Name string `mqp:"name"`
+	}
+	type S struct {
+		Values []string  `mqp:"values"`
+		Array  [2]int    `mqp:"array"`
+		Items  []Item    `mqp:"items,nested=dot"`
+		Ptrs   []*string `mqp:"ptrs"`
+		Other  []string  `mqp:"other,slice=repeat"`
+	}
+
+	b := "b"
+	v := S{
+		Values: []string{"a", "b"},
+		Array:  [2]int{1, 2},
+		Items:  []Item{{Name: "x"}, {Name: "y"}},
+		Ptrs:   []*string{nil, &b},
+		Other:  []string{"a", "b"},
+	}
+
+	got, err := mapqueryparam.NewEncoder(mapqueryparam.WithSliceStyle(mapqueryparam.SliceIndexed)).Encode(v)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := map[string][]string{
+		"values[0]":     {"a"},
+		"values[1]":     {"b"},
+		"array[0]":      {"1"},
+		"array[1]":      {"2"},
+		"items[0].name": {"x"},
+		"items[1].name": {"y"},
+		"ptrs[1]":       {"b"},
+		"other":         {"a", "b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+}
@@ -1,15 +1,92 @@
 package mapqueryparam
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The kinds of DecodeError, matched using errors.Is, e.g. `errors.Is(err, mapqueryparam.ErrSyntax)`.
+var (
+	// ErrInvalidTarget is the kind of error caused by decoding into a value which isn't a pointer to a struct, or a
+	// struct with invalid tags.
+	ErrInvalidTarget = errors.New("invalid decode target")
+	// ErrSyntax is the kind of error caused by a value which cannot be parsed as the type of its field.
+	ErrSyntax = errors.New("invalid syntax")
+	// ErrRange is the kind of error caused by a value or index which is out of range for its field.
+	ErrRange = errors.New("value out of range")
+	// ErrUnsupportedType is the kind of error caused by a field of a type which cannot be decoded.
+	ErrUnsupportedType = errors.New("unsupported type")
+	// ErrMissing is the kind of error caused by missing required parameters.
+	ErrMissing = errors.New("missing required parameter")
+	// ErrUnknownParameter is the kind of error caused by a parameter which doesn't match any field.
+	ErrUnknownParameter = errors.New("unknown parameter")
+	// ErrMaxDepth is the kind of error caused by structs nested deeper than the maximum depth. It's returned by Encode
+	// as well.
+	ErrMaxDepth = errors.New("maximum depth exceeded")
+	// ErrConflict is the kind of error caused by several parameters matching the same field with different values, when
+	// keys are matched case or separator insensitively.
+	ErrConflict = errors.New("conflicting parameters")
+	// ErrConstraint is the kind of error caused by a value failing a constraint of its field, such as min or pattern.
+	ErrConstraint = errors.New("constraint not satisfied")
+)
+
+// ErrCycle is returned by Encode when a value refers to itself through pointers.
+var ErrCycle = errors.New("encountered a cycle")
+
+// ErrInternal is the kind of error returned by Encode and Decode when they recover from a panic, such as one of a
+// marshaling method.
+var ErrInternal = errors.New("internal error")
 
 type DecodeError struct {
 	description string
 	field       string
 	err         error
+	kind        error
+	value       string
+	index       int
+	// keys holds the keys of the parameters causing the error, when it's caused by several missing or unknown ones.
+	keys []string
+}
+
+func newDecodeError(kind error, description string, field string, err error) DecodeError {
+	return DecodeError{description: description, field: field, err: err, kind: kind, index: -1}
+}
+
+// newValueError returns the error of a value which failed to decode. The offending parameter string is taken from the
+// error if it's a valueError, and the kind is derived from the error.
+func newValueError(description string, field string, err error) DecodeError {
+	res := newDecodeError(ErrSyntax, description, field, err)
+
+	var valueErr *valueError
+	if errors.As(err, &valueErr) {
+		res.err, res.value, res.index = valueErr.err, valueErr.value, valueErr.index
+	}
+
+	switch {
+	case errors.Is(err, ErrUnsupportedType):
+		res.kind = ErrUnsupportedType
+	case errors.Is(err, ErrConstraint):
+		res.kind = ErrConstraint
+	case errors.Is(err, strconv.ErrRange), errors.Is(err, ErrRange):
+		res.kind = ErrRange
+	}
+	return res
 }
 
-func newDecodeError(description string, field string, err error) DecodeError {
-	return DecodeError{description: description, field: field, err: err}
+func newMissingError(keys []string) DecodeError {
+	res := newDecodeError(ErrMissing, fmt.Sprintf("missing required parameters: %s", strings.Join(keys, ", ")), keys[0],
+		nil)
+	res.keys = keys
+	return res
+}
+
+func newUnknownError(keys []string) DecodeError {
+	res := newDecodeError(ErrUnknownParameter, fmt.Sprintf("unknown parameters: %s", strings.Join(keys, ", ")),
+		keys[0], nil)
+	res.keys = keys
+	return res
 }
 
 func (d DecodeError) Description() string {
@@ -20,13 +97,94 @@ func (d DecodeError) Field() string {
 	return d.field
 }
 
+// Missing returns the keys of all missing required parameters, when the error is caused by them. Field returns the
+// first of them.
+func (d DecodeError) Missing() []string {
+	if d.kind != ErrMissing {
+		return nil
+	}
+	return d.keys
+}
+
+// Unknown returns the keys of all unknown parameters, when the error is caused by them. Field returns the first of
+// them.
+func (d DecodeError) Unknown() []string {
+	if d.kind != ErrUnknownParameter {
+		return nil
+	}
+	return d.keys
+}
+
 func (d DecodeError) Err() error {
 	return d.err
 }
 
+// Unwrap returns the underlying error, the same as Err.
+func (d DecodeError) Unwrap() error {
+	return d.err
+}
+
+// Kind returns the kind of the error, which is one of the Err variables of the package, or nil if unknown.
+func (d DecodeError) Kind() error {
+	return d.kind
+}
+
+// Is reports whether the error is of the given kind.
+func (d DecodeError) Is(target error) bool {
+	return d.kind != nil && target == d.kind
+}
+
+// Value returns the parameter string which failed to decode, or the empty string if the error isn't caused by a single
+// value.
+func (d DecodeError) Value() string {
+	return d.value
+}
+
+// Index returns the position of the value which failed to decode among the values of the parameter, e.g. 1 for the
+// value `x` of `ids=1&ids=x` or `ids=1,x`. It returns -1 if the error isn't caused by a single value.
+func (d DecodeError) Index() int {
+	return d.index
+}
+
 func (d DecodeError) Error() string {
 	if d.err == nil {
 		return d.description
 	}
 	return fmt.Sprintf("%s: err %v", d.description, d.err)
 }
+
+// DecodeErrors holds the errors of all fields which failed to decode, when the decoder collects errors. The errors are
+// in the order of the fields.
+type DecodeErrors []DecodeError
+
+func (d DecodeErrors) Error() string {
+	s := make([]string, len(d))
+	for i, err := range d {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Unwrap returns the errors of the fields.
+func (d DecodeErrors) Unwrap() []error {
+	res := make([]error, len(d))
+	for i, err := range d {
+		res[i] = err
+	}
+	return res
+}
+
+// valueError is the error of a single parameter string, among the values of a parameter.
+type valueError struct {
+	value string
+	index int
+	err   error
+}
+
+func (e *valueError) Error() string {
+	return e.err.Error()
+}
+
+func (e *valueError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,64 @@
+package mapqueryparam_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/h-celel/mapqueryparam"
+)
+
+func TestDecodeError(t *testing.T) {
+	type S struct {
+		A int8        `mqp:"a"`
+		B []int       `mqp:"b,slice=indexed"`
+		C []int       `mqp:"c,slice=comma"`
+		D int         `mqp:"d,required"`
+		E []string    `mqp:"e,oneof=x|y"`
+		F interface{} `mqp:"f"`
+	}
+
+	tests := []struct {
+		name  string
+		query map[string][]string
+		v     interface{}
+		kind  error
+		field string
+		value string
+		index int
+	}{
+		{"InvalidTarget", nil, S{}, mapqueryparam.ErrInvalidTarget, "", "", -1},
+		{"Syntax", map[string][]string{"a": {"x"}, "d": {"1"}}, &S{}, mapqueryparam.ErrSyntax, "a", "x", 0},
+		{"Range", map[string][]string{"a": {"300"}, "d": {"1"}}, &S{}, mapqueryparam.ErrRange, "a", "300", 0},
+		{"Index", map[string][]string{"b": {"1", "2", "x"}, "d": {"1"}}, &S{}, mapqueryparam.ErrSyntax, "b", "x", 2},
+		{"Delimited", map[string][]string{"c": {"1,x"}, "d": {"1"}}, &S{}, mapqueryparam.ErrSyntax, "c", "x", 1},
+		{"MaxIndex", map[string][]string{"b[2000]": {"1"}, "d": {"1"}}, &S{}, mapqueryparam.ErrRange, "b[2000]", "2000", -1},
+		{"Missing", map[string][]string{}, &S{}, mapqueryparam.ErrMissing, "d", "", -1},
+		{"Constraint", map[string][]string{"e": {"x", "z"}, "d": {"1"}}, &S{}, mapqueryparam.ErrConstraint, "e", "z", 1},
+		{"Unsupported", map[string][]string{"f": {"1"}, "d": {"1"}}, &S{}, mapqueryparam.ErrUnsupportedType, "f", "1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mapqueryparam.Decode(tt.query, tt.v)
+			var decodeErr mapqueryparam.DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("Decode() error = %v, want DecodeError", err)
+			}
+			if !errors.Is(err, tt.kind) || decodeErr.Kind() != tt.kind {
+				t.Errorf("Decode() error kind = %v, want %v", decodeErr.Kind(), tt.kind)
+			}
+			if decodeErr.Field() != tt.field || decodeErr.Value() != tt.value || decodeErr.Index() != tt.index {
+				t.Errorf("Decode() error field, value, index = %s, %s, %d, want %s, %s, %d", decodeErr.Field(),
+					decodeErr.Value(), decodeErr.Index(), tt.field, tt.value, tt.index)
+			}
+		})
+	}
+
+	err := mapqueryparam.Decode(map[string][]string{"a": {"300"}, "d": {"1"}}, &S{})
+	if !errors.Is(err, strconv.ErrRange) {
+		t.Errorf("Decode() error = %v, want to wrap strconv.ErrRange", err)
+	}
+	if errors.Is(err, mapqueryparam.ErrSyntax) {
+		t.Errorf("Decode() error = %v, want not to be ErrSyntax", err)
+	}
+}
@@ -0,0 +1,166 @@
+//go:build go1.18
+// +build go1.18
+
+package mapqueryparam_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/h-celel/mapqueryparam"
+)
+
+// FuzzInner and FuzzEmbedded are exported, as the fields of unexported embedded structs are neither encoded nor
+// decoded.
+type FuzzInner struct {
+	Name  string            `mqp:"name"`
+	Age   *int              `mqp:"age"`
+	Tags  []string          `mqp:"tags"`
+	Meta  map[string]string `mqp:"meta"`
+	Next  *FuzzInner        `mqp:"next"`
+	Items []FuzzInner       `mqp:"items"`
+}
+
+type FuzzEmbedded struct {
+	Page int `mqp:"page,default=1"`
+}
+
+// fuzzList and fuzzMap contain themselves as elements.
+type fuzzList []fuzzList
+
+type fuzzMap map[string]fuzzMap
+
+type fuzzStruct struct {
+	*FuzzEmbedded
+	FuzzInner
+
+	A      int8                `mqp:"a,min=-10,max=10"`
+	B      uint16              `mqp:"b"`
+	C      float32             `mqp:"c"`
+	D      complex64           `mqp:"d"`
+	E      bool                `mqp:"e,required_with=a"`
+	F      string              `mqp:"f,f2,pattern=^[a-z]*$"`
+	G      [3]int              `mqp:"g,slice=comma"`
+	H      []uint              `mqp:"h,slice=indexed"`
+	I      time.Time           `mqp:"i"`
+	J      *time.Time          `mqp:"j"`
+	K      textStatus          `mqp:"k"`
+	L      []textPoint         `mqp:"l,slice=pipe"`
+	M      boundingBox         `mqp:"m"`
+	N      map[int]bool        `mqp:"n,nested=dot"`
+	O      map[string][]string `mqp:"o"`
+	P      interface{}         `mqp:"p"`
+	Q      **string            `mqp:"q"`
+	Inner  FuzzInner           `mqp:"x,nested=dot"`
+	Ptr    *FuzzInner          `mqp:"y,nested=bracket"`
+	JSON   FuzzInner           `mqp:"z,nested=json"`
+	R      fuzzList            `mqp:"r"`
+	S      fuzzMap             `mqp:"s,nested=dot"`
+	Extra  url.Values          `mqp:",remain"`
+	Ignore chan int            `mqp:"ignore"`
+}
+
+// fuzzEncoders covers the combinations of options affecting which fields are encoded.
+var fuzzEncoders = []*mapqueryparam.Encoder{
+	mapqueryparam.NewEncoder(),
+	mapqueryparam.NewEncoder(mapqueryparam.WithOmitEmpty(false), mapqueryparam.WithNesting(mapqueryparam.NestingDot)),
+}
+
+// fuzzDecoders covers the combinations of options affecting how keys and values are read.
+var fuzzDecoders = []*mapqueryparam.Decoder{
+	mapqueryparam.NewDecoder(),
+	mapqueryparam.NewDecoder(
+		mapqueryparam.WithNesting(mapqueryparam.NestingBracket),
+		mapqueryparam.WithCollectErrors(true),
+		mapqueryparam.WithCaseInsensitive(true),
+	),
+	mapqueryparam.NewDecoder(
+		mapqueryparam.WithNesting(mapqueryparam.NestingDot),
+		mapqueryparam.WithSliceStyle(mapqueryparam.SliceSpace),
+		mapqueryparam.WithIgnoreSeparators(true),
+		mapqueryparam.WithStrict(true),
+		mapqueryparam.WithMaxDepth(3),
+	),
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, s := range []string{
+		"",
+		"a=1&b=2&c=1.5&d=1i&e=true&f=x",
+		"g=1,2,3,4&h[0]=1&h[5]=2&h[]=3&h=4",
+		"i=2021-03-04T00:00:00Z&j=1614816000&k=active&l=1:2|3:4&m=1&m=2&m=3&m=4",
+		"n.1=true&n.x=false&o[a][]=b&p=1&q=s",
+		"x.name=a&x.next.next.name=b&x.items[0].tags[1]=c&y[next][next][next][name]=d",
+		"z={\"name\":\"a\"}&name=b&page=x&next.name=c&A=1&F2=y&utm_source=z",
+		"h[99999999999999999999]=1&a[=1&a]=1&.=1&[]=1&x..name=1&y[[name]]=1",
+		"h[1001]=1&N.1=x&g=%2C%25,&l=%7C",
+		"r=1&r[0][1]=2&r[1][0][0]=3&s.a.b=1&s.a=2",
+	} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		query, err := url.ParseQuery(s)
+		if err != nil {
+			t.Skip()
+		}
+
+		for _, dec := range fuzzDecoders {
+			var v fuzzStruct
+			err := dec.Decode(query, &v)
+			if errors.Is(err, mapqueryparam.ErrInternal) {
+				t.Fatalf("Decode() panicked: %v", err)
+			}
+			if err != nil {
+				continue
+			}
+
+			// nil embedded pointers are encoded as well, as decoding allocates them
+			noEmbedded := v
+			noEmbedded.FuzzEmbedded = nil
+			for _, enc := range fuzzEncoders {
+				for _, e := range []fuzzStruct{v, noEmbedded} {
+					if _, err := enc.Encode(e); errors.Is(err, mapqueryparam.ErrInternal) {
+						t.Fatalf("Encode() panicked: %v", err)
+					}
+				}
+			}
+		}
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	type S struct {
+		Name  string   `mqp:"name"`
+		Tags  []string `mqp:"tags,slice=comma"`
+		Words []string `mqp:"words,slice=space"`
+		Pipes []string `mqp:"pipes,slice=pipe"`
+		Count int      `mqp:"count"`
+	}
+
+	f.Add("a", "b,c", "d e", "f|g", 1)
+	f.Add("", "%", "%20", "%7C", -1)
+
+	f.Fuzz(func(t *testing.T, name string, tag string, word string, pipe string, count int) {
+		v := S{Name: name, Tags: []string{tag, "x"}, Words: []string{word, "x"}, Pipes: []string{pipe, "x"}, Count: count}
+
+		query, err := mapqueryparam.Encode(v)
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+
+		var got S
+		if err := mapqueryparam.Decode(query, &got); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+
+		// empty elements of delimited slices aren't preserved
+		if got.Name != v.Name || got.Count != v.Count ||
+			(tag != "" && got.Tags[0] != tag) || (word != "" && got.Words[0] != word) ||
+			(pipe != "" && got.Pipes[0] != pipe) {
+			t.Errorf("Decode() got = %+v, want %+v", got, v)
+		}
+	})
+}
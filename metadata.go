@@ -0,0 +1,82 @@
+package mapqueryparam
+
+import (
+	"net/url"
+	"sort"
+)
+
+// Metadata reports how a query was decoded, as returned by DecodeWithMetadata. Fields are identified by the key they're
+// encoded with, i.e. their first name joined to the key of any struct they're nested in.
+type Metadata struct {
+	// Keys holds the keys of the query consumed by fields, in sorted order.
+	Keys []string
+	// Unused holds the keys of the query not consumed by any field, in sorted order. Keys stored in a remain field are
+	// included.
+	Unused []string
+	// Aliases holds the key each decoded field was found by, which differs from the key of the field when an alias
+	// matched, e.g. `n` for a field tagged with `mqp:"name,n"`.
+	Aliases map[string]string
+	// Unset holds the fields which weren't found in the query and kept their previous value, or the zero value.
+	Unset []string
+	// Defaults holds the fields which weren't found in the query and were set to their default value.
+	Defaults []string
+}
+
+// DecodeWithMetadata decodes the query the same way as Decode, and returns the metadata of the decoding. The metadata
+// is returned when decoding fails as well, covering the fields decoded before the failure.
+func DecodeWithMetadata(query map[string][]string, v interface{}) (Metadata, error) {
+	return defaultDecoder.DecodeWithMetadata(query, v)
+}
+
+// DecodeValuesWithMetadata is the same as DecodeWithMetadata.
+func DecodeValuesWithMetadata(query url.Values, v interface{}) (Metadata, error) {
+	return defaultDecoder.DecodeWithMetadata(query, v)
+}
+
+// DecodeWithMetadata decodes the query the same way as Decode, and returns the metadata of the decoding. The metadata
+// is returned when decoding fails as well, covering the fields decoded before the failure.
+func (d *Decoder) DecodeWithMetadata(query map[string][]string, v interface{}) (Metadata, error) {
+	meta := Metadata{Aliases: make(map[string]string)}
+	err := d.decode(query, v, &meta)
+	return meta, err
+}
+
+// DecodeValuesWithMetadata is the same as DecodeWithMetadata.
+func (d *Decoder) DecodeValuesWithMetadata(query url.Values, v interface{}) (Metadata, error) {
+	return d.DecodeWithMetadata(query, v)
+}
+
+// addAlias records the key the field was found by. It does nothing if the metadata isn't recorded.
+func (m *Metadata) addAlias(key string, f *fieldPlan, nesting NestingStyle, found string) {
+	if m == nil {
+		return
+	}
+	m.Aliases[joinKey(key, f.names[0], nesting)] = found
+}
+
+// addUnset records a field which kept its previous value. It does nothing if the metadata isn't recorded. Fields
+// excluded from decoding aren't recorded.
+func (m *Metadata) addUnset(key string, f *fieldPlan, nesting NestingStyle) {
+	if m == nil || f.decode == nil {
+		return
+	}
+	m.Unset = append(m.Unset, joinKey(key, f.names[0], nesting))
+}
+
+// addDefault records a field set to its default value. It does nothing if the metadata isn't recorded.
+func (m *Metadata) addDefault(key string, f *fieldPlan, nesting NestingStyle) {
+	if m == nil {
+		return
+	}
+	m.Defaults = append(m.Defaults, joinKey(key, f.names[0], nesting))
+}
+
+// setKeys records the used and unused keys of the query.
+func (m *Metadata) setKeys(st *decodeState) {
+	m.Keys = nil
+	for key := range st.used {
+		m.Keys = append(m.Keys, key)
+	}
+	sort.Strings(m.Keys)
+	m.Unused = st.unused(nil)
+}
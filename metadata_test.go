@@ -0,0 +1,50 @@
+package mapqueryparam_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/h-celel/mapqueryparam"
+)
+
+func TestDecodeWithMetadata(t *testing.T) {
+	type Filter struct {
+		Age int `mqp:"age"`
+	}
+	type S struct {
+		Name   string `mqp:"name,n"`
+		Limit  int    `mqp:"limit,default=20"`
+		Page   int    `mqp:"page"`
+		Filter Filter `mqp:"filter,nested=dot"`
+		Token  string `mqp:"-"`
+	}
+
+	query := map[string][]string{"n": {"x"}, "filter.age": {"3"}, "pgae": {"2"}, "Token": {"t"}}
+	v := S{Page: 4}
+	meta, err := mapqueryparam.DecodeWithMetadata(query, &v)
+	if err != nil {
+		t.Fatalf("DecodeWithMetadata() error = %v", err)
+	}
+
+	want := mapqueryparam.Metadata{
+		Keys:     []string{"filter.age", "n"},
+		Unused:   []string{"Token", "pgae"},
+		Aliases:  map[string]string{"name": "n", "filter.age": "filter.age", "filter": "filter"},
+		Unset:    []string{"page"},
+		Defaults: []string{"limit"},
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("DecodeWithMetadata() got = %+v, want %+v", meta, want)
+	}
+	if v.Name != "x" || v.Limit != 20 || v.Page != 4 || v.Filter.Age != 3 {
+		t.Errorf("DecodeWithMetadata() got = %+v", v)
+	}
+
+	meta, err = mapqueryparam.DecodeWithMetadata(map[string][]string{"page": {"x"}}, &v)
+	if err == nil {
+		t.Errorf("DecodeWithMetadata() expected error")
+	}
+	if !reflect.DeepEqual(meta.Unset, []string{"name", "limit"}) || !reflect.DeepEqual(meta.Keys, []string{"page"}) {
+		t.Errorf("DecodeWithMetadata() got = %+v, want metadata of fields decoded before the error", meta)
+	}
+}
@@ -0,0 +1,65 @@
+package mapqueryparam
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NamingStrategy derives the name of a parameter from the name of a struct field, for fields without a name in their
+// tags. Custom strategies can be used as well as the predefined ones.
+type NamingStrategy func(fieldName string) string
+
+// SnakeCase is the naming strategy converting field names to snake case, e.g. `page_size` for `PageSize` and `user_id`
+// for `UserID`.
+func SnakeCase(fieldName string) string {
+	return strings.Join(lowerWords(fieldName), "_")
+}
+
+// KebabCase is the naming strategy converting field names to kebab case, e.g. `page-size` for `PageSize` and
+// `user-id` for `UserID`.
+func KebabCase(fieldName string) string {
+	return strings.Join(lowerWords(fieldName), "-")
+}
+
+// CamelCase is the naming strategy converting field names to camel case, e.g. `pageSize` for `PageSize` and `userId`
+// for `UserID`.
+func CamelCase(fieldName string) string {
+	words := lowerWords(fieldName)
+	for i := 1; i < len(words); i++ {
+		r, size := utf8.DecodeRuneInString(words[i])
+		words[i] = string(unicode.ToUpper(r)) + words[i][size:]
+	}
+	return strings.Join(words, "")
+}
+
+// LowerCase is the naming strategy converting field names to lower case, e.g. `pagesize` for `PageSize`.
+func LowerCase(fieldName string) string {
+	return strings.ToLower(fieldName)
+}
+
+// lowerWords splits the name into words in lower case. Words are separated by underscores, and start at each upper
+// case letter following a lower case letter or digit, as well as at the last upper case letter of an acronym followed
+// by a lower case letter, e.g. `http`, `server` and `id` for `HTTPServerID`.
+func lowerWords(name string) []string {
+	var res []string
+	for _, part := range strings.Split(name, "_") {
+		runes := []rune(part)
+		start := 0
+		for i := 1; i < len(runes); i++ {
+			if !unicode.IsUpper(runes[i]) {
+				continue
+			}
+			prev := runes[i-1]
+			endOfAcronym := unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || endOfAcronym {
+				res = append(res, strings.ToLower(string(runes[start:i])))
+				start = i
+			}
+		}
+		if start < len(runes) {
+			res = append(res, strings.ToLower(string(runes[start:])))
+		}
+	}
+	return res
+}
@@ -0,0 +1,102 @@
+package mapqueryparam_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/h-celel/mapqueryparam"
+)
+
+func TestNamingStrategy(t *testing.T) {
+	tests := []struct {
+		name   string
+		naming mapqueryparam.NamingStrategy
+		in     []string
+		want   []string
+	}{
+		{
+			name:   "SnakeCase",
+			naming: mapqueryparam.SnakeCase,
+			in:     []string{"PageSize", "UserID", "HTTPServerID", "Page2Size", "Page_Size", "A"},
+			want:   []string{"page_size", "user_id", "http_server_id", "page2_size", "page_size", "a"},
+		},
+		{
+			name:   "KebabCase",
+			naming: mapqueryparam.KebabCase,
+			in:     []string{"PageSize", "UserID"},
+			want:   []string{"page-size", "user-id"},
+		},
+		{
+			name:   "CamelCase",
+			naming: mapqueryparam.CamelCase,
+			in:     []string{"PageSize", "UserID", "HTTPServer", "A"},
+			want:   []string{"pageSize", "userId", "httpServer", "a"},
+		},
+		{
+			name:   "LowerCase",
+			naming: mapqueryparam.LowerCase,
+			in:     []string{"PageSize", "UserID"},
+			want:   []string{"pagesize", "userid"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, in := range tt.in {
+				if got := tt.naming(in); got != tt.want[i] {
+					t.Errorf("%s(%s) got = %s, want %s", tt.name, in, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWithNaming(t *testing.T) {
+	type Filter struct {
+		MinAge int
+	}
+	type Embedded struct {
+		PageSize int
+	}
+	type S struct {
+		Embedded
+		UserID int
+		Tagged int    `mqp:"Tagged"`
+		JSON   int    `json:"Json"`
+		Filter Filter `mqp:"filter,nested=dot"`
+	}
+
+	v := S{Embedded{10}, 1, 2, 3, Filter{18}}
+	want := map[string][]string{
+		"page_size":      {"10"},
+		"user_id":        {"1"},
+		"Tagged":         {"2"},
+		"Json":           {"3"},
+		"filter.min_age": {"18"},
+	}
+
+	opts := []mapqueryparam.Option{mapqueryparam.WithNaming(mapqueryparam.SnakeCase)}
+	got, err := mapqueryparam.NewEncoder(opts...).Encode(v)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() got = %v, want %v", got, want)
+	}
+
+	var decoded S
+	if err := mapqueryparam.NewDecoder(opts...).Decode(want, &decoded); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(decoded, v) {
+		t.Errorf("Decode() got = %+v, want %+v", decoded, v)
+	}
+
+	got, err = mapqueryparam.NewEncoder(mapqueryparam.WithNaming(strings.ToUpper)).Encode(Filter{18})
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, map[string][]string{"MINAGE": {"18"}}) {
+		t.Errorf("Encode() got = %v, want custom naming", got)
+	}
+}
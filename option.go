@@ -0,0 +1,268 @@
+package mapqueryparam
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Option configures an Encoder or a Decoder. Options that only affect one direction are ignored by the other, so the
+// same set of options can be shared by an Encoder and a Decoder.
+type Option func(*config)
+
+// NestingStyle determines how the fields of nested structs are represented as query parameters.
+type NestingStyle int
+
+const (
+	// NestingJSON encodes nested structs and maps as a single parameter using the marshal function, json by default.
+	NestingJSON NestingStyle = iota
+	// NestingDot flattens nested structs and maps into one parameter per field or entry, joining the keys with dots,
+	// e.g. `filter.name=x&filter.age=3` or `labels.env=prod`.
+	NestingDot
+	// NestingBracket flattens nested structs, maps, arrays and slices using brackets, as used by Rails, PHP, the qs
+	// library and the OpenAPI deepObject style, e.g. `user[name]=x&user[tags][]=a&user[labels][env]=prod`. Slices are
+	// decoded from both the append style `tags[]` and the indexed style `tags[0]`, as well as repeated keys.
+	NestingBracket
+)
+
+// SliceStyle determines how arrays and slices of values are represented as query parameters. The styles correspond to
+// the OpenAPI styles for query parameters.
+type SliceStyle int
+
+const (
+	// SliceRepeat repeats the key for each value, e.g. `ids=1&ids=2`, like the OpenAPI form style with explode.
+	SliceRepeat SliceStyle = iota
+	// SliceComma joins the values with commas, e.g. `ids=1,2`, like the OpenAPI form style without explode.
+	SliceComma
+	// SliceSpace joins the values with spaces, e.g. `ids=1%202`, like the OpenAPI spaceDelimited style.
+	SliceSpace
+	// SlicePipe joins the values with pipes, e.g. `ids=1|2`, like the OpenAPI pipeDelimited style.
+	SlicePipe
+	// SliceIndexed stores each value under an indexed key, e.g. `ids[0]=1&ids[1]=2`. Indexed keys are also decoded
+	// using NestingDot or NestingBracket, regardless of the slice style.
+	SliceIndexed
+)
+
+// delimiter returns the delimiter joining the values of the style, or the empty string if values aren't delimited.
+func (s SliceStyle) delimiter() string {
+	switch s {
+	case SliceComma:
+		return ","
+	case SliceSpace:
+		return " "
+	case SlicePipe:
+		return "|"
+	default:
+		return ""
+	}
+}
+
+// config holds the settings shared by Encoder and Decoder.
+type config struct {
+	tagName       string
+	timeLayout    string
+	timeLocation  *time.Location
+	omitEmpty     bool
+	nesting       NestingStyle
+	sliceStyle    SliceStyle
+	maxIndex      int
+	maxDepth      int
+	collectErrors bool
+	strict        bool
+	allowed       []string
+	ignoreCase    bool
+	ignoreSeps    bool
+	naming        NamingStrategy
+	marshal       func(v interface{}) ([]byte, error)
+	unmarshal     func(data []byte, v interface{}) error
+	converters    map[reflect.Type]converter
+
+	plans *planCache
+}
+
+// newConfig returns the default configuration with the given options applied.
+func newConfig(opts []Option) config {
+	c := config{
+		tagName:    mapQueryParameterTagName,
+		timeLayout: time.RFC3339Nano,
+		omitEmpty:  true,
+		maxIndex:   defaultMaxIndex,
+		maxDepth:   defaultMaxDepth,
+		marshal:    json.Marshal,
+		unmarshal:  json.Unmarshal,
+		converters: map[reflect.Type]converter{timeType: {format: timeConverter}},
+		plans:      &planCache{},
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// fieldOptions returns the default options of the fields of a struct.
+func (c *config) fieldOptions() fieldOptions {
+	return fieldOptions{nesting: c.nesting, slices: c.sliceStyle}
+}
+
+// normalizer returns the function normalizing keys for matching, or nil if keys are matched exactly.
+func (c *config) normalizer() func(key string) string {
+	if !c.ignoreCase && !c.ignoreSeps {
+		return nil
+	}
+
+	var r *strings.Replacer
+	if c.ignoreSeps {
+		r = strings.NewReplacer("_", "", "-", "")
+	}
+	return func(key string) string {
+		if c.ignoreCase {
+			key = strings.ToLower(key)
+		}
+		if r != nil {
+			key = r.Replace(key)
+		}
+		return key
+	}
+}
+
+// WithTagName sets the name of the struct tag used to identify fields. Defaults to "mqp". The json tag is still used as
+// a fallback when the field has no such tag.
+func WithTagName(name string) Option {
+	return func(c *config) {
+		c.tagName = name
+	}
+}
+
+// WithTimeLayout sets the layout used to format time.Time values. Defaults to time.RFC3339Nano. Besides layouts, the
+// names of predefined layouts such as "rfc1123" or "dateonly" are accepted, as well as "unix" and "unixmilli" for the
+// number of seconds or milliseconds since the unix epoch. The layout can be overridden per field using the time tag
+// option, e.g. `mqp:"since,time=2006-01-02"`. When decoding, values must match the layout, except for the default
+// layout, which falls back to unix seconds and json.
+func WithTimeLayout(layout string) Option {
+	return func(c *config) {
+		c.timeLayout = layout
+	}
+}
+
+// WithTimeLocation sets the location of decoded time.Time values without a time zone, such as those of the layout
+// "2006-01-02" and unix times. By default, layouts are decoded in UTC and unix times in the local time zone, as by
+// time.Parse and time.Unix. Times are converted to the location when encoding, so they're formatted the same way.
+func WithTimeLocation(loc *time.Location) Option {
+	return func(c *config) {
+		c.timeLocation = loc
+	}
+}
+
+// WithOmitEmpty sets whether empty/zero values are omitted when encoding. Defaults to true. The setting can be
+// overridden per field using the keepzero and omitempty tag options, e.g. `mqp:"page,keepzero"`. Nil pointers,
+// interfaces, maps and slices, as well as channels and functions, are always omitted, so pointers can be used to
+// distinguish a zero value from an absent one.
+func WithOmitEmpty(omitEmpty bool) Option {
+	return func(c *config) {
+		c.omitEmpty = omitEmpty
+	}
+}
+
+// WithMarshalFunc sets the function used to encode maps and structs. Defaults to json.Marshal.
+func WithMarshalFunc(marshal func(v interface{}) ([]byte, error)) Option {
+	return func(c *config) {
+		c.marshal = marshal
+	}
+}
+
+// WithUnmarshalFunc sets the function used to decode maps and structs. Defaults to json.Unmarshal.
+func WithUnmarshalFunc(unmarshal func(data []byte, v interface{}) error) Option {
+	return func(c *config) {
+		c.unmarshal = unmarshal
+	}
+}
+
+// WithNesting sets how nested values are represented. Defaults to NestingJSON. The style applies at any depth, and can
+// be overridden per field using the nested tag option, e.g. `mqp:"filter,nested=dot"` or `mqp:"user,nested=bracket"`.
+func WithNesting(style NestingStyle) Option {
+	return func(c *config) {
+		c.nesting = style
+	}
+}
+
+// WithSliceStyle sets how arrays and slices of values are represented when encoding, and how values are delimited when
+// decoding. Defaults to SliceRepeat. The style can be overridden per field using the slice tag option, e.g.
+// `mqp:"ids,slice=comma"`. Delimiters within values are escaped by percent-encoding them, along with the percent sign.
+// Indexed keys are decoded regardless of the style.
+func WithSliceStyle(style SliceStyle) Option {
+	return func(c *config) {
+		c.sliceStyle = style
+	}
+}
+
+// WithMaxIndex sets the largest index accepted in indexed keys when decoding, e.g. `ids[1000]`. Defaults to 1000.
+// Larger indexes are rejected, as the decoded slice is allocated to fit the largest index.
+func WithMaxIndex(maxIndex int) Option {
+	return func(c *config) {
+		c.maxIndex = maxIndex
+	}
+}
+
+// WithCollectErrors sets whether decoding continues after a field fails to decode, collecting the errors of all fields.
+// Defaults to false, returning the first error. When set, the errors are returned as DecodeErrors, which holds a
+// DecodeError for each failed field and each missing required parameter. The decoded value is left unchanged if any
+// field fails.
+func WithCollectErrors(collect bool) Option {
+	return func(c *config) {
+		c.collectErrors = collect
+	}
+}
+
+// WithStrict sets whether decoding fails on parameters which aren't consumed by any field, returning a DecodeError of
+// kind ErrUnknownParameter listing them. Defaults to false, ignoring unknown parameters. Parameters allowed using
+// WithAllowedParameters are ignored in strict mode as well.
+func WithStrict(strict bool) Option {
+	return func(c *config) {
+		c.strict = strict
+	}
+}
+
+// WithAllowedParameters adds patterns of parameters ignored by strict decoding, e.g. `utm_*` or `_`. The patterns use
+// the syntax of path.Match. Malformed patterns don't match any parameter.
+func WithAllowedParameters(patterns ...string) Option {
+	return func(c *config) {
+		c.allowed = append(c.allowed, patterns...)
+	}
+}
+
+// WithCaseInsensitive sets whether parameters are matched to fields regardless of case when decoding, e.g. `PageSize`
+// and `pagesize` for a field named `pageSize`. Defaults to false. When several parameters match the same field, the
+// exact match is used, followed by the first matching parameter in sorted order. Matching parameters with different
+// values cause a DecodeError of kind ErrConflict.
+func WithCaseInsensitive(ignoreCase bool) Option {
+	return func(c *config) {
+		c.ignoreCase = ignoreCase
+	}
+}
+
+// WithIgnoreSeparators sets whether underscores and dashes are ignored when matching parameters to fields when
+// decoding, e.g. `page_size` and `page-size` for a field named `pagesize`. Defaults to false. Conflicts are resolved
+// the same way as by WithCaseInsensitive.
+func WithIgnoreSeparators(ignoreSeps bool) Option {
+	return func(c *config) {
+		c.ignoreSeps = ignoreSeps
+	}
+}
+
+// WithNaming sets the naming strategy deriving the names of fields without a name in their tags from the names of the
+// fields, e.g. SnakeCase. Defaults to nil, using the names of the fields as is. The strategy applies to both encoding
+// and decoding, and to the fields of nested structs.
+func WithNaming(naming NamingStrategy) Option {
+	return func(c *config) {
+		c.naming = naming
+	}
+}
+
+// WithMaxDepth sets the maximum depth of nested structs when encoding and decoding, e.g. 2 for `a.b.c`. Defaults to
+// 32. Deeper structs cause an error of kind ErrMaxDepth.
+func WithMaxDepth(maxDepth int) Option {
+	return func(c *config) {
+		c.maxDepth = maxDepth
+	}
+}
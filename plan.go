@@ -0,0 +1,525 @@
+package mapqueryparam
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	valuesType          = reflect.TypeOf(map[string][]string(nil))
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	marshalerType       = reflect.TypeOf((*QueryParamMarshaler)(nil)).Elem()
+	unmarshalerType     = reflect.TypeOf((*QueryParamUnmarshaler)(nil)).Elem()
+)
+
+// structPlan describes how the fields of a struct type are encoded and decoded. Plans are compiled once per type and
+// cached, so the struct tags and embedded structs are only inspected the first time a type is seen.
+type structPlan struct {
+	// opts holds the default options of the fields. Its nesting style is also used to join the keys of the fields to
+	// the key of the struct, when the struct is nested in another.
+	opts   fieldOptions
+	fields []*fieldPlan
+	// required reports whether any of the fields is required when decoding.
+	required bool
+	// remain is the field holding the parameters not consumed by other fields, or nil if there is none.
+	remain *fieldPlan
+	// err holds the error met when compiling the plan, e.g. due to an invalid struct tag.
+	err error
+}
+
+// fieldPlan describes a single field of a struct. Fields promoted from embedded structs are part of the plan of the
+// outer struct, and are reached through their index path.
+type fieldPlan struct {
+	// name is the name of the struct field.
+	name string
+	// names holds the names the field is identified by. The first name is used when encoding.
+	names []string
+	// index is the sequence of field indexes leading to the field from the outer struct.
+	index []int
+	// typ is the type of the field.
+	typ reflect.Type
+	// omitEmpty reports whether empty values of the field are omitted when encoding.
+	omitEmpty bool
+	// required reports whether the field must be present when decoding.
+	required bool
+	// requiredWith holds the names of the parameters which make the field required when present, and requiredWithIdx
+	// holds the positions of the corresponding fields in the plan.
+	requiredWith    []string
+	requiredWithIdx []int
+	// defaults holds the parameter strings decoded when the parameter is absent, or nil if the field has no default.
+	defaults []string
+	// validate checks the decoded value of the field, or is nil if the field has no constraints.
+	validate fieldValidator
+
+	// encode is nil if the field is excluded from encoding, and decode is nil if the field is excluded from decoding.
+	encode fieldEncoder
+	decode fieldDecoder
+}
+
+// fieldOptions holds the options of a field which are inherited by the fields of nested structs.
+type fieldOptions struct {
+	nesting NestingStyle
+	slices  SliceStyle
+	// time is the format of time.Time values, or the empty string if the configured layout is used.
+	time string
+}
+
+// planKey identifies a plan in the cache. The same struct type is planned separately for each set of default options,
+// as the options are inherited from the field containing the struct.
+type planKey struct {
+	typ  reflect.Type
+	opts fieldOptions
+}
+
+// planCache is a concurrency safe cache of struct plans.
+type planCache struct {
+	plans sync.Map
+}
+
+// reset discards all cached plans.
+func (c *planCache) reset() {
+	c.plans.Range(func(key, _ interface{}) bool {
+		c.plans.Delete(key)
+		return true
+	})
+}
+
+// structPlan returns the plan for the given struct type and default field options, compiling and caching it on first
+// use.
+func (c *config) structPlan(t reflect.Type, opts fieldOptions) (*structPlan, error) {
+	key := planKey{typ: t, opts: opts}
+	if p, ok := c.plans.plans.Load(key); ok {
+		return p.(*structPlan), p.(*structPlan).err
+	}
+
+	p := &structPlan{opts: opts}
+	p.err = c.compileFields(p, t, nil, map[reflect.Type]bool{t: true})
+	if p.err == nil {
+		p.err = p.resolveRequired()
+	}
+
+	actual, _ := c.plans.plans.LoadOrStore(key, p)
+	return actual.(*structPlan), actual.(*structPlan).err
+}
+
+// compileFields adds the fields of the given struct type to the plan. Fields of embedded structs are added in place of
+// the embedded field itself. Embedded types that are already being compiled are skipped to avoid infinite recursion.
+func (c *config) compileFields(p *structPlan, t reflect.Type, index []int, visited map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		// unexported fields are neither encoded nor decoded
+		isUnexported := f.PkgPath != ""
+		if isUnexported {
+			continue
+		}
+
+		fIndex := make([]int, len(index)+1)
+		copy(fIndex, index)
+		fIndex[len(index)] = i
+
+		// excluded fields are kept in the plan without encoder and decoder, so decoding keeps their original value
+		tag := parseFieldTag(f, c.tagName, c.naming)
+		if tag.skip {
+			p.fields = append(p.fields, &fieldPlan{name: f.Name, index: fIndex, typ: f.Type})
+			continue
+		}
+
+		if f.Anonymous {
+			fTyp := indirectType(f.Type)
+			if fTyp.Kind() == reflect.Struct {
+				if !visited[fTyp] {
+					visited[fTyp] = true
+					if err := c.compileFields(p, fTyp, fIndex, visited); err != nil {
+						return err
+					}
+					delete(visited, fTyp)
+				}
+				continue
+			}
+		}
+
+		// the remain field is kept in the plan without encoder and decoder, as it's handled separately
+		if tag.hasOption(remainOption) {
+			if !f.Type.ConvertibleTo(valuesType) {
+				return fmt.Errorf("invalid tag of field '%s': remain field must be of type map[string][]string", f.Name)
+			}
+			if p.remain != nil {
+				return fmt.Errorf("invalid tag of field '%s': remain field already set by field '%s'", f.Name,
+					p.remain.name)
+			}
+			p.remain = &fieldPlan{name: f.Name, index: fIndex, typ: f.Type}
+			p.fields = append(p.fields, p.remain)
+			continue
+		}
+
+		if tag.invalid != "" {
+			return fmt.Errorf("invalid tag of field '%s': name '%s' contains reserved characters, option values "+
+				"cannot contain commas", f.Name, tag.invalid)
+		}
+		opts, err := tag.fieldOptions(p.opts)
+		if err != nil {
+			return fmt.Errorf("invalid tag of field '%s': %w", f.Name, err)
+		}
+		omitEmpty, err := tag.omitEmpty(c.omitEmpty)
+		if err != nil {
+			return fmt.Errorf("invalid tag of field '%s': %w", f.Name, err)
+		}
+
+		encodeOnly, decodeOnly := tag.hasOption(encodeOnlyOption), tag.hasOption(decodeOnlyOption)
+		if encodeOnly && decodeOnly {
+			return fmt.Errorf("invalid tag of field '%s': options '%s' and '%s' are mutually exclusive", f.Name,
+				encodeOnlyOption, decodeOnlyOption)
+		}
+
+		fp := &fieldPlan{
+			name:      f.Name,
+			names:     tag.names,
+			index:     fIndex,
+			typ:       f.Type,
+			omitEmpty: omitEmpty,
+			required:  tag.hasOption(requiredOption),
+		}
+		if !decodeOnly {
+			fp.encode = c.fieldEncoder(f.Type, opts)
+		}
+		if !encodeOnly {
+			fp.decode = c.fieldDecoder(f.Type, opts)
+		}
+
+		fp.validate, err = c.fieldValidator(f.Type, tag, opts)
+		if err != nil {
+			return fmt.Errorf("invalid tag of field '%s': %w", f.Name, err)
+		}
+
+		if s, ok := tag.options[requiredWithOption]; ok {
+			fp.requiredWith = strings.Split(s, "|")
+		}
+		if (fp.required || fp.requiredWith != nil) && encodeOnly {
+			return fmt.Errorf("invalid tag of field '%s': required field is excluded from decoding", f.Name)
+		}
+
+		if s, ok := tag.options[defaultOption]; ok {
+			// nested structs and maps are decoded from several parameters, and cannot have a default
+			fTyp := indirectType(f.Type)
+			if c.isSequence(fTyp) {
+				fTyp = indirectType(fTyp.Elem())
+			}
+			if c.isNested(fTyp, opts) && !c.isSequence(fTyp) {
+				return fmt.Errorf("invalid tag of field '%s': default value not supported for nested type %s", f.Name,
+					f.Type.String())
+			}
+			if err := fp.compileDefault(s); err != nil {
+				return fmt.Errorf("invalid tag of field '%s': %w", f.Name, err)
+			}
+		}
+
+		p.fields = append(p.fields, fp)
+	}
+	return nil
+}
+
+// resolveRequired finds the fields referenced by the required_with options of the plan, and records whether the plan
+// has any required fields.
+func (p *structPlan) resolveRequired() error {
+	for _, f := range p.fields {
+		if f.required || f.requiredWith != nil {
+			p.required = true
+		}
+		for _, name := range f.requiredWith {
+			idx := p.fieldIndex(name)
+			if idx < 0 {
+				return fmt.Errorf("invalid tag of field '%s': unknown parameter '%s' in option '%s'", f.name, name,
+					requiredWithOption)
+			}
+			f.requiredWithIdx = append(f.requiredWithIdx, idx)
+		}
+	}
+	return nil
+}
+
+// fieldIndex returns the position in the plan of the decoded field identified by the given name, or -1 if there is
+// none.
+func (p *structPlan) fieldIndex(name string) int {
+	for i, f := range p.fields {
+		if f.decode == nil {
+			continue
+		}
+		for _, n := range f.names {
+			if n == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// compileDefault sets the default value of the field, and validates it by decoding it once and checking the decoded
+// value against the constraints of the field.
+func (f *fieldPlan) compileDefault(s string) error {
+	if f.decode == nil {
+		return fmt.Errorf("default of field excluded from decoding")
+	}
+	if f.required {
+		return fmt.Errorf("options '%s' and '%s' are mutually exclusive", requiredOption, defaultOption)
+	}
+
+	f.defaults = []string{s}
+	if t := indirectType(f.typ); t.Kind() == reflect.Array || t.Kind() == reflect.Slice {
+		f.defaults = strings.Split(s, "|")
+	}
+
+	v := reflect.New(f.typ).Elem()
+	ok, err := f.decodeDefault(v)
+	if err != nil {
+		return fmt.Errorf("invalid default value '%s': %w", s, err)
+	}
+	if !ok {
+		return fmt.Errorf("default value not supported for type %s", f.typ.String())
+	}
+	if f.validate != nil {
+		if err := f.validate(v); err != nil {
+			return fmt.Errorf("invalid default value '%s': %w", s, err)
+		}
+	}
+	return nil
+}
+
+// decodeDefault decodes the default value of the field, and reports whether it was decoded. The value is decoded anew
+// each time, so defaults of slices and pointers aren't shared between decoded values.
+func (f *fieldPlan) decodeDefault(v reflect.Value) (bool, error) {
+	key := f.names[0]
+	match, err := f.decode(&decodeState{query: map[string][]string{key: f.defaults}}, key, zeroValue, v)
+	return match != "", err
+}
+
+// isRequired reports whether the field must be present when decoding, given which fields of the plan are present.
+func (f *fieldPlan) isRequired(present []bool) bool {
+	if f.required {
+		return true
+	}
+	for _, i := range f.requiredWithIdx {
+		if present[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// lazyFieldEncoder returns the encoder for a field of the given type, compiling it on first use. Encoders of the
+// elements of arrays, slices and maps are compiled lazily, so types containing themselves, e.g. `type L []L`, don't
+// recurse endlessly when compiling, the same way as the plans of nested structs.
+func (c *config) lazyFieldEncoder(t reflect.Type, opts fieldOptions) fieldEncoder {
+	var once sync.Once
+	var enc fieldEncoder
+	return func(st *encodeState, key string, v reflect.Value) error {
+		once.Do(func() { enc = c.fieldEncoder(t, opts) })
+		return enc(st, key, v)
+	}
+}
+
+// lazyFieldDecoder returns the decoder for a field of the given type, compiling it on first use, the same way as
+// lazyFieldEncoder.
+func (c *config) lazyFieldDecoder(t reflect.Type, opts fieldOptions) fieldDecoder {
+	var once sync.Once
+	var dec fieldDecoder
+	return func(st *decodeState, key string, old reflect.Value, v reflect.Value) (string, error) {
+		once.Do(func() { dec = c.fieldDecoder(t, opts) })
+		return dec(st, key, old, v)
+	}
+}
+
+// isCustom reports whether values of the given type are handled by a converter or any of the marshaling interfaces, in
+// which case they're always encoded and decoded as a whole.
+func (c *config) isCustom(t reflect.Type) bool {
+	if _, ok := c.converters[t]; ok {
+		return true
+	}
+	for _, iface := range []reflect.Type{marshalerType, textMarshalerType} {
+		if implementsMarshaler(t, iface) {
+			return true
+		}
+	}
+	for _, iface := range []reflect.Type{unmarshalerType, textUnmarshalerType} {
+		if implementsUnmarshaler(t, iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNested reports whether values of the given type are spread over several parameters, using keys nested under the
+// key of the value. Structs, and maps with keys that can be represented as text, are nested in all styles but
+// NestingJSON. Arrays and slices are nested using NestingBracket, unless their values are delimited.
+func (c *config) isNested(t reflect.Type, opts fieldOptions) bool {
+	if opts.nesting == NestingJSON || c.isCustom(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return true
+	case reflect.Map:
+		return c.isMapKey(t.Key())
+	case reflect.Array, reflect.Slice:
+		return opts.nesting == NestingBracket && opts.slices == SliceRepeat
+	}
+	return false
+}
+
+// isMapKey reports whether map keys of the given type can be represented as text in a nested key. Keys must be basic
+// types, or handled by a converter or the text marshaling interfaces.
+func (c *config) isMapKey(t reflect.Type) bool {
+	if _, ok := c.converters[t]; ok {
+		return true
+	}
+	if implementsMarshaler(t, textMarshalerType) && implementsUnmarshaler(t, textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// isSequence reports whether values of the given type are arrays or slices of separately encoded elements.
+func (c *config) isSequence(t reflect.Type) bool {
+	return (t.Kind() == reflect.Array || t.Kind() == reflect.Slice) && !c.isCustom(t)
+}
+
+// indirectType returns the type pointed to by the given type, following any number of pointers.
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// indirectValue returns the value pointed to by the given value, following any number of pointers. It returns the zero
+// Value if a nil pointer is met.
+func indirectValue(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return zeroValue
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// indexKey returns the key of an element of an array or slice, given the key of the array or slice and the index.
+func indexKey(key string, index string) string {
+	return key + "[" + index + "]"
+}
+
+// nestedPrefix returns the prefix of the keys nested under the given key using the given style.
+func nestedPrefix(key string, nesting NestingStyle) string {
+	switch nesting {
+	case NestingDot:
+		return key + "."
+	default:
+		return key + "["
+	}
+}
+
+// joinKey returns the key of a field of a nested struct, given the key of the struct.
+func joinKey(prefix string, name string, nesting NestingStyle) string {
+	if prefix == "" {
+		return name
+	}
+	switch nesting {
+	case NestingDot:
+		return prefix + "." + name
+	case NestingBracket:
+		return prefix + "[" + name + "]"
+	default:
+		return name
+	}
+}
+
+// keySeparators returns the characters which separate the segments of keys nested using the given style. Map keys
+// containing them cannot be told apart from the keys of nested values.
+func keySeparators(nesting NestingStyle) string {
+	switch nesting {
+	case NestingDot:
+		return ".["
+	case NestingBracket:
+		return "[]"
+	default:
+		return ""
+	}
+}
+
+// fieldByIndex returns the field at the given index path. It returns the zero value if a nil embedded pointer is met
+// along the path.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 {
+			for v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return zeroValue
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// fieldByIndexAlloc returns the field at the given index path, allocating any nil embedded pointers along the path.
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 {
+			for v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					v.Set(reflect.New(v.Type().Elem()))
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// implementsMarshaler reports whether values of the given type, or pointers to them, implement the given marshaler
+// interface. Pointer and interface types are excluded, as they're dereferenced before being encoded.
+func implementsMarshaler(t reflect.Type, iface reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return false
+	}
+	return t.Implements(iface) || reflect.PtrTo(t).Implements(iface)
+}
+
+// implementsUnmarshaler reports whether pointers to values of the given type implement the given unmarshaler
+// interface. Pointer and interface types are excluded, as they're allocated and dereferenced before being decoded.
+func implementsUnmarshaler(t reflect.Type, iface reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return false
+	}
+	return reflect.PtrTo(t).Implements(iface)
+}
+
+// marshalerValue returns the value, or a pointer to it, implementing the given marshaler interface. Values whose method
+// has a pointer receiver are copied when they're not addressable.
+func marshalerValue(v reflect.Value, iface reflect.Type) reflect.Value {
+	if v.Type().Implements(iface) {
+		return v
+	}
+	if v.CanAddr() {
+		return v.Addr()
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p
+}
@@ -0,0 +1,154 @@
+package mapqueryparam
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldTag holds the names and options parsed from the struct tags of a field.
+type fieldTag struct {
+	// names holds the names the field is identified by. The first name is used when encoding.
+	names []string
+	// options holds the options of the field, keyed by option name.
+	options map[string]string
+	// skip reports whether the field is excluded from both encoding and decoding.
+	skip bool
+	// invalid holds the first name of the given tag containing reserved characters, if any.
+	invalid string
+}
+
+// parseFieldTag returns the names and options that a struct field is identified by. It prioritizes the names of the
+// given tag over the json tag. It defaults to the field name, converted by the naming strategy if any, if neither tag
+// is available. Entries of the given tag in the form `option=value`, as well as flags such as `keepzero` after the
+// first entry, are options rather than names. As in encoding/json, a tag of `-` excludes the field, while `-,` names
+// it `-`.
+func parseFieldTag(f reflect.StructField, tagName string, naming NamingStrategy) fieldTag {
+	var res fieldTag
+
+	tags := f.Tag.Get(tagName)
+	if tags == skipTag {
+		res.skip = true
+		return res
+	}
+	if len(tags) > 0 {
+		for i, s := range strings.Split(tags, ",") {
+			if i := strings.IndexByte(s, '='); i >= 0 {
+				if res.options == nil {
+					res.options = make(map[string]string)
+				}
+				res.options[s[:i]] = s[i+1:]
+				continue
+			}
+			if i > 0 && flagOptions[s] {
+				if res.options == nil {
+					res.options = make(map[string]string)
+				}
+				res.options[s] = ""
+				continue
+			}
+			if strings.ContainsAny(s, reservedNameChars) && res.invalid == "" {
+				res.invalid = s
+			}
+			if len(s) > 0 {
+				res.names = append(res.names, s)
+			}
+		}
+	}
+
+	// ignore json tags and field name if the tag contains names
+	if len(res.names) > 0 {
+		return res
+	}
+
+	jsonTags := f.Tag.Get("json")
+	if jsonTags == skipTag {
+		res.skip = true
+		return res
+	}
+	if len(jsonTags) > 0 {
+		if name := strings.Split(jsonTags, ",")[0]; len(name) > 0 {
+			res.names = append(res.names, name)
+		}
+	}
+
+	// ignore field name if json tag is present
+	if len(res.names) > 0 {
+		return res
+	}
+
+	if naming != nil {
+		res.names = append(res.names, naming(f.Name))
+	} else {
+		res.names = append(res.names, f.Name)
+	}
+
+	return res
+}
+
+// hasOption reports whether the given option is set in the tag.
+func (t fieldTag) hasOption(name string) bool {
+	_, ok := t.options[name]
+	return ok
+}
+
+// omitEmpty returns whether empty values of the field are omitted when encoding. The keepzero and omitempty flags
+// override the given default.
+func (t fieldTag) omitEmpty(def bool) (bool, error) {
+	keepZero, omitEmpty := t.hasOption(keepZeroOption), t.hasOption(omitEmptyOption)
+	switch {
+	case keepZero && omitEmpty:
+		return def, fmt.Errorf("options '%s' and '%s' are mutually exclusive", keepZeroOption, omitEmptyOption)
+	case keepZero:
+		return false, nil
+	case omitEmpty:
+		return true, nil
+	default:
+		return def, nil
+	}
+}
+
+// fieldOptions returns the inheritable options of the field. Options which aren't set in the tag are inherited from
+// the given options.
+func (t fieldTag) fieldOptions(parent fieldOptions) (fieldOptions, error) {
+	res := parent
+
+	if s, ok := t.options[nestedOption]; ok {
+		switch s {
+		case "json":
+			res.nesting = NestingJSON
+		case "dot":
+			res.nesting = NestingDot
+		case "bracket":
+			res.nesting = NestingBracket
+		default:
+			return res, fmt.Errorf("unknown nesting style '%s'", s)
+		}
+	}
+
+	if s, ok := t.options[sliceOption]; ok {
+		switch s {
+		case "repeat":
+			res.slices = SliceRepeat
+		case "comma":
+			res.slices = SliceComma
+		case "space":
+			res.slices = SliceSpace
+		case "pipe":
+			res.slices = SlicePipe
+		case "indexed":
+			res.slices = SliceIndexed
+		default:
+			return res, fmt.Errorf("unknown slice style '%s'", s)
+		}
+	}
+
+	if s, ok := t.options[timeOption]; ok {
+		if s == "" {
+			return res, fmt.Errorf("empty time format")
+		}
+		res.time = s
+	}
+
+	return res, nil
+}
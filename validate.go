@@ -0,0 +1,210 @@
+package mapqueryparam
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fieldValidator checks a decoded field against the constraints of its tag.
+type fieldValidator func(v reflect.Value) error
+
+// elemValidator checks a single decoded value, or element of an array, slice or map, against a constraint.
+type elemValidator func(v reflect.Value) error
+
+// fieldValidator returns the validator checking values of the given type against the constraints of the tag, or nil if
+// the tag has none. The constraints min, max, len, oneof and pattern apply to each element of arrays, slices and maps,
+// while maxitems applies to their length. Constraints are validated against the type, so invalid tags are reported
+// when compiling the plan.
+func (c *config) fieldValidator(t reflect.Type, tag fieldTag, opts fieldOptions) (fieldValidator, error) {
+	t = indirectType(t)
+
+	elemT := t
+	isContainer := false
+	switch t.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map:
+		if !c.isCustom(t) {
+			elemT = indirectType(t.Elem())
+			isContainer = true
+		}
+	}
+
+	var checks []elemValidator
+	for _, name := range []string{minOption, maxOption, lenOption, oneOfOption, patternOption} {
+		s, ok := tag.options[name]
+		if !ok {
+			continue
+		}
+
+		check, err := c.elemValidator(elemT, name, s, opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid option '%s': %w", name, err)
+		}
+		checks = append(checks, check)
+	}
+
+	maxItems := -1
+	if s, ok := tag.options[maxItemsOption]; ok {
+		if !isContainer {
+			return nil, fmt.Errorf("invalid option '%s': not supported for type %s", maxItemsOption, t.String())
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid option '%s': '%s' is not a valid length", maxItemsOption, s)
+		}
+		maxItems = n
+	}
+
+	if len(checks) == 0 && maxItems < 0 {
+		return nil, nil
+	}
+
+	return func(v reflect.Value) error {
+		v = indirectValue(v)
+		if !v.IsValid() {
+			return nil
+		}
+		if !isContainer {
+			return runChecks(checks, v, 0)
+		}
+
+		if maxItems >= 0 && v.Len() > maxItems {
+			return fmt.Errorf("%w: %d items exceed the maximum of %d", ErrConstraint, v.Len(), maxItems)
+		}
+		if v.Kind() == reflect.Map {
+			iter := v.MapRange()
+			for iter.Next() {
+				if err := runChecks(checks, indirectValue(iter.Value()), -1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := runChecks(checks, indirectValue(v.Index(i)), i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// elemValidator returns the validator for the constraint with the given name and argument, checking values of the
+// given type decoded using the given options.
+func (c *config) elemValidator(t reflect.Type, name string, arg string, opts fieldOptions) (elemValidator, error) {
+	switch name {
+	case minOption, maxOption:
+		bound, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", arg)
+		}
+		size, err := sizeOf(t)
+		if err != nil {
+			return nil, err
+		}
+		if name == minOption {
+			return func(v reflect.Value) error {
+				if size(v) < bound {
+					return fmt.Errorf("%s is less than the minimum of %s", describeSize(v), arg)
+				}
+				return nil
+			}, nil
+		}
+		return func(v reflect.Value) error {
+			if size(v) > bound {
+				return fmt.Errorf("%s is greater than the maximum of %s", describeSize(v), arg)
+			}
+			return nil
+		}, nil
+	case lenOption:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("'%s' is not a valid length", arg)
+		}
+		if t.Kind() != reflect.String {
+			return nil, fmt.Errorf("not supported for type %s", t.String())
+		}
+		return func(v reflect.Value) error {
+			if l := utf8.RuneCountInString(v.String()); l != n {
+				return fmt.Errorf("length %d is not equal to %d", l, n)
+			}
+			return nil
+		}, nil
+	case oneOfOption:
+		dec := c.valueDecoder(t, opts)
+		var allowed []reflect.Value
+		for _, s := range strings.Split(arg, "|") {
+			a := reflect.New(t).Elem()
+			if err := dec(s, a); err != nil {
+				return nil, fmt.Errorf("invalid value '%s': %w", s, err)
+			}
+			allowed = append(allowed, a)
+		}
+		return func(v reflect.Value) error {
+			for _, a := range allowed {
+				if reflect.DeepEqual(v.Interface(), a.Interface()) {
+					return nil
+				}
+			}
+			return fmt.Errorf("value is not one of %s", strings.ReplaceAll(arg, "|", ", "))
+		}, nil
+	case patternOption:
+		if t.Kind() != reflect.String {
+			return nil, fmt.Errorf("not supported for type %s", t.String())
+		}
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value) error {
+			if !re.MatchString(v.String()) {
+				return fmt.Errorf("value does not match pattern %s", arg)
+			}
+			return nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown constraint")
+	}
+}
+
+// sizeOf returns the function measuring values of the given type for the min and max constraints. Numbers are measured
+// by their value, and strings by their length.
+func sizeOf(t reflect.Type) (func(v reflect.Value) float64, error) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) float64 { return float64(v.Int()) }, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(v reflect.Value) float64 { return float64(v.Uint()) }, nil
+	case reflect.Float32, reflect.Float64:
+		return func(v reflect.Value) float64 { return v.Float() }, nil
+	case reflect.String:
+		return func(v reflect.Value) float64 { return float64(utf8.RuneCountInString(v.String())) }, nil
+	default:
+		return nil, fmt.Errorf("not supported for type %s", t.String())
+	}
+}
+
+// describeSize describes the measure of a value used by the min and max constraints.
+func describeSize(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return fmt.Sprintf("length %d", utf8.RuneCountInString(v.String()))
+	}
+	return fmt.Sprintf("value %v", v.Interface())
+}
+
+// runChecks runs the validators on the value, returning the first error. The error holds the value and its index among
+// the values of the field.
+func runChecks(checks []elemValidator, v reflect.Value, index int) error {
+	if !v.IsValid() {
+		return nil
+	}
+	for _, check := range checks {
+		if err := check(v); err != nil {
+			return &valueError{value: fmt.Sprint(v.Interface()), index: index, err: fmt.Errorf("%w: %v", ErrConstraint, err)}
+		}
+	}
+	return nil
+}